@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+func init() {
+	log.SetFlags(log.Ldate | log.Ltime)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags] <command>
+
+Commands:
+  list     list the backups in -dir, newest first
+  backup   back up -db to a new file in -dir (the webapp does this itself with -backup-dir)
+  restore  check that the -from backup is intact, then swap it in as -db; stop the webapp first
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbfile := flag.String("db", "store.sqlite", "sqlite database file")
+	dir := flag.String("dir", "backups", "backup directory")
+	from := flag.String("from", "", "backup file to restore, or \"latest\" for the newest one in -dir")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "list":
+		backups, err := storage.ListBackups(*dir)
+		if err != nil {
+			log.Fatalf("failed to list backups: %s", err)
+		}
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\n", b.Time.Local().Format(time.RFC3339), b.Path)
+		}
+	case "backup":
+		if _, err := os.Stat(*dbfile); err != nil {
+			log.Fatalf("failed to open database: %s", err)
+		}
+		db, err := storage.Open(*dbfile, storage.DefaultSettings())
+		if err != nil {
+			log.Fatalf("failed to open sqlite database: %s", err)
+		}
+		defer db.Close()
+
+		path, err := storage.BackupDatabase(ctx, db.Write, *dir, time.Now())
+		if err != nil {
+			log.Fatalf("backup failed: %s", err)
+		}
+		log.Printf("backed up %s to %s", *dbfile, path)
+	case "restore":
+		path := *from
+		if path == "latest" {
+			backups, err := storage.ListBackups(*dir)
+			if err != nil {
+				log.Fatalf("failed to list backups: %s", err)
+			}
+			if len(backups) == 0 {
+				log.Fatalf("no backups in %s", *dir)
+			}
+			path = backups[0].Path
+		}
+		if path == "" {
+			log.Fatalf("restore needs a backup to restore -from")
+		}
+
+		aside, err := storage.RestoreDatabase(ctx, path, *dbfile)
+		if err != nil {
+			log.Fatalf("restore failed: %s", err)
+		}
+		log.Printf("restored %s from %s", *dbfile, path)
+		if aside != "" {
+			log.Printf("the previous database was kept as %s", aside)
+		}
+	default:
+		log.Printf("unknown command %q", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
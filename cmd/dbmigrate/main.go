@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+func init() {
+	log.SetFlags(log.Ldate | log.Ltime)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags] <command>
+
+Commands:
+  status   show the database's schema version, and any migrations still to be applied
+  dry-run  apply the pending migrations in a transaction that is rolled back, to check that they would succeed
+  up       apply the pending migrations (the webapp and stravasync also do this when they start)
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbfile := flag.String("db", "store.sqlite", "sqlite database file")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	if _, err := os.Stat(*dbfile); err != nil {
+		log.Fatalf("failed to open database: %s", err)
+	}
+	db, err := storage.Open(*dbfile, storage.DefaultSettings())
+	if err != nil {
+		log.Fatalf("failed to open sqlite database: %s", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("warning: failed to cleanly close database: %s", err)
+		}
+	}()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "status":
+		status, err := storage.ReadMigrationStatus(ctx, db.Write)
+		if err != nil {
+			log.Fatalf("failed to read migration status: %s", err)
+		}
+		log.Printf("database is at version %d; the latest migration is %d", status.Current, status.Latest)
+		if status.Current > status.Latest {
+			log.Printf("database is ahead of this binary, which will refuse to use it")
+		}
+		for _, m := range status.Pending {
+			log.Printf("pending: %s", m.Name)
+		}
+	case "dry-run", "up":
+		applied, err := storage.MigrateDatabase(ctx, db.Write, cmd == "dry-run")
+		for _, m := range applied {
+			if cmd == "dry-run" {
+				log.Printf("would apply: %s", m.Name)
+			} else {
+				log.Printf("applied: %s", m.Name)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, storage.ErrDatabaseAhead) {
+				log.Fatalf("refusing to migrate: %s", err)
+			}
+			log.Fatalf("%s failed: %s", cmd, err)
+		}
+		if len(applied) == 0 {
+			log.Printf("database is up to date")
+		}
+	default:
+		log.Printf("unknown command %q", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+	"github.com/ianrose14/website/internal/strava"
+)
+
+var (
+	stravaClientID     = os.Getenv("STRAVA_CLIENT_ID")
+	stravaClientSecret = os.Getenv("STRAVA_SECRET")
+)
+
+func init() {
+	log.SetFlags(log.Ldate | log.Ltime)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags] <command>
+
+Commands:
+  backfill  fetch the athlete's full activity history (resumes if interrupted)
+  resync    re-fetch all activities between -from and -to
+  verify    compare stored activities between -from and -to against Strava
+  import    add the activities in a Strava export zip (-archive) to the local store, without using the API
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbfile := flag.String("db", "store.sqlite", "sqlite database file")
+	username := flag.String("username", "", "Strava username of the athlete to sync")
+	from := flag.String("from", "", "start date (YYYY-MM-DD) for resync and verify; defaults to the start of history")
+	to := flag.String("to", "", "end date (YYYY-MM-DD, exclusive) for resync and verify; defaults to now")
+	archive := flag.String("archive", "", "Strava \"download your data\" export zip, for import")
+	notify := flag.String("notify", "", "where to send achievement notifications: \"log\", smtp://host:port?from=..&to=.., or a webhook URL")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 || *username == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	start := time.Unix(0, 0)
+	finish := time.Now()
+	if *from != "" {
+		t, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			log.Fatalf("invalid -from date: %s", err)
+		}
+		start = t
+	}
+	if *to != "" {
+		t, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			log.Fatalf("invalid -to date: %s", err)
+		}
+		finish = t
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		log.Printf("received %q signal, stopping (progress so far is saved)", sig)
+		cancel()
+	}()
+
+	db, err := storage.Open(*dbfile, storage.DefaultSettings())
+	if err != nil {
+		log.Fatalf("failed to open sqlite database: %s", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("warning: failed to cleanly close database: %s", err)
+		}
+	}()
+
+	applied, err := storage.MigrateDatabase(ctx, db.Write, false)
+	if err != nil {
+		if errors.Is(err, storage.ErrDatabaseAhead) {
+			log.Fatalf("refusing to start: %s", err)
+		}
+		log.Fatalf("failed to migrate database: %s", err)
+	}
+	for _, m := range applied {
+		log.Printf("applied database migration %s", m.Name)
+	}
+
+	settings, err := db.Describe(ctx)
+	if err != nil {
+		log.Fatalf("failed to read database settings: %s", err)
+	}
+	for _, line := range settings {
+		log.Printf("database %s", line)
+	}
+
+	stravaDb := strava.NewSqliteDb(db)
+	watcher := &strava.MilestoneWatcher{Db: stravaDb}
+	if *notify != "" {
+		watcher.Notifier, err = strava.NewNotifier(*notify)
+		if err != nil {
+			log.Fatalf("failed to configure notifier: %s", err)
+		}
+	}
+	stravaDb.AddListener(watcher.ActivitiesArrived)
+
+	syncer := &strava.Syncer{
+		Db: stravaDb,
+		Account: &strava.ApiParams{
+			ClientId:     stravaClientID,
+			ClientSecret: stravaClientSecret,
+		},
+		Username: *username,
+		Limiter:  &strava.RateLimiter{Logf: log.Printf},
+		Logf:     log.Printf,
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "backfill":
+		err = syncer.Backfill(ctx)
+	case "resync":
+		log.Printf("re-syncing activities from %s to %s", start.Format("2006-01-02"), finish.Format("2006-01-02"))
+		err = syncer.Resync(ctx, start, finish)
+	case "verify":
+		log.Printf("verifying activities from %s to %s", start.Format("2006-01-02"), finish.Format("2006-01-02"))
+		var report *strava.SyncReport
+		report, err = syncer.Verify(ctx, start, finish)
+		if err == nil {
+			log.Printf("%d activities on Strava, %d stored locally", report.Remote, report.Local)
+			for _, id := range report.Missing {
+				log.Printf("missing locally: %d", id)
+			}
+			for _, id := range report.Extra {
+				log.Printf("deleted on Strava: %d", id)
+			}
+			for _, id := range report.Changed {
+				log.Printf("changed on Strava: %d", id)
+			}
+			if !report.Ok() {
+				log.Printf("verify found differences; run resync to fix them")
+				os.Exit(1)
+			}
+			log.Printf("all stored activities match Strava")
+		}
+	case "import":
+		if *archive == "" {
+			log.Fatalf("import needs an -archive to import")
+		}
+		var report *strava.ArchiveReport
+		report, err = importArchive(ctx, stravaDb, *username, *archive)
+		if err == nil {
+			log.Print(report)
+		}
+	default:
+		log.Printf("unknown command %q", cmd)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		if err == strava.ErrNeedsAuth {
+			log.Fatalf("no tokens stored for %q; sign in via the webapp first", *username)
+		}
+		log.Fatalf("%s failed: %s", flag.Arg(0), err)
+	}
+}
+
+func importArchive(ctx context.Context, db *strava.SqliteDb, username, filename string) (*strava.ArchiveReport, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("importing %s", filename)
+	return strava.ImportArchive(ctx, db, username, f, info.Size())
+}
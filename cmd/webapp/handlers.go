@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -26,6 +27,7 @@ var (
 	albumsTemplate  = template.Must(template.ParseFS(templatesFS, "templates/albums.html"))
 	allisonTemplate = template.Must(template.ParseFS(templatesFS, "templates/allison.html"))
 
+	adminToken         = os.Getenv("ADMIN_TOKEN")
 	dropboxAccessToken = os.Getenv("DROPBOX_TOKEN")
 	stravaClientID     = os.Getenv("STRAVA_CLIENT_ID")
 	stravaClientSecret = os.Getenv("STRAVA_SECRET")
@@ -158,6 +160,34 @@ func (svr *server) dumpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// backupHandler takes a database backup on demand.  It's only available when backups are configured (with -backup-dir)
+// and ADMIN_TOKEN is set, and must be sent that token as a bearer token.
+func (svr *server) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if svr.backups == nil || adminToken == "" {
+		internal.HttpError(w, http.StatusNotFound, "backups are not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		internal.HttpError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	path, err := svr.backups.Backup(r.Context())
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "backup failed: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "backed up to %s\n", path)
+}
+
 func (svr *server) scholarshipFundHandler(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "Coming soon!  Please check back in a few weeks.")
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"embed"
 	_ "embed"
 	"errors"
@@ -21,12 +20,9 @@ import (
 
 	"github.com/ianrose14/website/internal/storage"
 	"github.com/ianrose14/website/internal/strava"
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-const inDev = runtime.GOOS == "darwin"
-
 var (
 	//go:embed static/*
 	staticFS embed.FS
@@ -41,7 +37,14 @@ var (
 	templatesFS embed.FS
 
 	//stravaVars = &internal.MemoryDatabase{vals: make(map[string]*internal.StravaTokens)}
-	stravaTemplate = template.Must(template.ParseFS(templatesFS, "templates/strava.html"))
+	stravaTemplate    = template.Must(template.ParseFS(templatesFS, "templates/strava.html"))
+	activityTemplate  = template.Must(template.ParseFS(templatesFS, "templates/activity.html"))
+	shareTemplate     = template.Must(template.ParseFS(templatesFS, "templates/share.html"))
+	vdotTemplate      = template.Must(template.ParseFS(templatesFS, "templates/vdot.html"))
+	logTemplate       = template.Must(template.ParseFS(templatesFS, "templates/traininglog.html"))
+	chartsTemplate    = template.Must(template.ParseFS(templatesFS, "templates/charts.html"))
+	challengeTemplate = template.Must(template.ParseFS(templatesFS, "templates/challenge.html"))
+	reviewTemplate    = template.Must(template.ParseFS(templatesFS, "templates/review.html"))
 
 	baseHosts = []string{
 		"ianthomasrose.com",
@@ -56,8 +59,20 @@ func init() {
 func main() {
 	certsDir := flag.String("certs", "certs", "Directory to store letsencrypt certs")
 	dbfile := flag.String("db", "store.sqlite", "sqlite database file")
+	inDev := flag.Bool("dev", runtime.GOOS == "darwin", "development mode: serve plain http only, without letsencrypt certs")
+	demo := flag.Bool("demo", false, "serve fixture Strava athletes instead of talking to Strava (implies -dev)")
+	httpAddr := flag.String("http", ":http", "listen address for the http server")
+	backupDir := flag.String("backup-dir", "", "directory for periodic database backups; no backups are taken if empty")
+	backupInterval := flag.Duration("backup-interval", 6*time.Hour, "how often to back up the database")
+	backupRetention := flag.String("backup-retention", "last=8,daily=7,weekly=8", "which backups to keep: the most recent N, and the newest of each of the last N days and weeks")
+	ageFactorsFile := flag.String("age-factors", "", "CSV of WMA age-grading tables for the VDOT calculator (see strava.AgeFactors); age grading is off if empty")
+	notify := flag.String("notify", "", "where to send achievement notifications: \"log\", smtp://host:port?from=..&to=.., or a webhook URL")
 	flag.Parse()
 
+	if *demo {
+		*inDev = true
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -69,7 +84,7 @@ func main() {
 		cancel()
 	}()
 
-	log.Printf("Starting up, with -certs=%s, -db=%s", *certsDir, *dbfile)
+	log.Printf("Starting up, with -certs=%s, -db=%s, -dev=%t, -demo=%t", *certsDir, *dbfile, *inDev, *demo)
 
 	s, err := filepath.Abs(*certsDir)
 	if err != nil {
@@ -77,9 +92,9 @@ func main() {
 	}
 	certsDir = &s
 
-	db, err := sql.Open("sqlite3", "file:"+*dbfile+"?cache=shared")
+	db, err := storage.Open(*dbfile, storage.DefaultSettings())
 	if err != nil {
-		log.Fatalf("failed to open sqlite connection: %s", err)
+		log.Fatalf("failed to open sqlite database: %s", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -87,16 +102,59 @@ func main() {
 		}
 	}()
 
-	if err := storage.UpsertDatabaseTables(ctx, db); err != nil {
-		log.Fatalf("failed to upsert database tables: %s", err)
+	applied, err := storage.MigrateDatabase(ctx, db.Write, false)
+	if err != nil {
+		if errors.Is(err, storage.ErrDatabaseAhead) {
+			log.Fatalf("refusing to start: %s", err)
+		}
+		log.Fatalf("failed to migrate database: %s", err)
+	}
+	for _, m := range applied {
+		log.Printf("applied database migration %s", m.Name)
+	}
+
+	settings, err := db.Describe(ctx)
+	if err != nil {
+		log.Fatalf("failed to read database settings: %s", err)
+	}
+	for _, line := range settings {
+		log.Printf("database %s", line)
 	}
 
 	svr := &server{db: db}
+	if *backupDir != "" {
+		retention, err := storage.ParseRetention(*backupRetention)
+		if err != nil {
+			log.Fatalf("invalid -backup-retention: %s", err)
+		}
+		svr.backups = &storage.Backups{
+			Db:        db.Write, // VACUUM INTO isn't allowed on the query-only readers
+			Dir:       *backupDir,
+			Interval:  *backupInterval,
+			Retention: retention,
+			Logf:      log.Printf,
+		}
+		log.Printf("backing up the database to %s every %s, keeping %s", *backupDir, *backupInterval, retention)
+		go svr.backups.Run(ctx)
+	}
 
 	stravaAccount := &strava.ApiParams{
 		ClientId:     stravaClientID,
 		ClientSecret: stravaClientSecret,
-		Hostname:     baseHosts[0],
+		Hosts:        []string{baseHosts[0], "www." + baseHosts[0]},
+		Dev:          *inDev,
+	}
+	if *demo {
+		stravaAccount.Demo = true
+		stravaAccount.Client = strava.NewDemoClient()
+	}
+
+	var ageFactors *strava.AgeFactors
+	if *ageFactorsFile != "" {
+		ageFactors, err = strava.LoadAgeFactors(*ageFactorsFile)
+		if err != nil {
+			log.Fatalf("failed to load age-grading tables: %s", err)
+		}
 	}
 
 	httpFS := func(files embed.FS, subdir string) http.Handler {
@@ -119,8 +177,24 @@ func main() {
 	baseMux.HandleFunc("/albums/thumbnail/", svr.thumbnailHandler)
 	baseMux.HandleFunc("/allison", svr.allisonHandler)
 	baseMux.HandleFunc("/dump/", svr.dumpHandler)
+	baseMux.HandleFunc("/admin/backup", svr.backupHandler)
 
 	stravaDb := strava.NewSqliteDb(db)
+	{
+		watcher := &strava.MilestoneWatcher{Db: stravaDb}
+		if *notify != "" {
+			watcher.Notifier, err = strava.NewNotifier(*notify)
+			if err != nil {
+				log.Fatalf("failed to configure notifier: %s", err)
+			}
+		}
+		stravaDb.AddListener(watcher.ActivitiesArrived)
+	}
+	liveUpdates := strava.NewLiveUpdates(ctx)
+	stravaDb.AddListener(liveUpdates.ActivitiesArrived)
+	if *demo {
+		baseMux.HandleFunc("/strava/demo/authorize", strava.DemoAuthorizeHandler)
+	}
 	baseMux.HandleFunc("/strava/exchange_token/", func(w http.ResponseWriter, r *http.Request) {
 		strava.TokenHandler(w, r, stravaDb, stravaAccount)
 	})
@@ -131,6 +205,61 @@ func main() {
 		baseMux.HandleFunc("/running/", h)
 		baseMux.HandleFunc("/strava/", h)
 	}
+	baseMux.HandleFunc("/running/events", func(w http.ResponseWriter, r *http.Request) {
+		strava.LiveHandler(w, r, stravaDb, liveUpdates)
+	})
+	baseMux.HandleFunc("/running/races/", func(w http.ResponseWriter, r *http.Request) {
+		strava.RacesHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/goals/", func(w http.ResponseWriter, r *http.Request) {
+		strava.GoalsHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/share/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ShareSettingsHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/u/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ShareHandler(w, r, shareTemplate, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/activity/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ActivityHandler(w, r, activityTemplate, stravaDb, stravaAccount)
+	})
+	{
+		h := func(w http.ResponseWriter, r *http.Request) {
+			strava.VdotHandler(w, r, vdotTemplate, stravaDb, stravaAccount, ageFactors)
+		}
+		baseMux.HandleFunc("/running/vdot/", h)
+		baseMux.HandleFunc("/running/vdot.json", h)
+	}
+	baseMux.HandleFunc("/running/log/", func(w http.ResponseWriter, r *http.Request) {
+		strava.TrainingLogHandler(w, r, logTemplate, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/charts/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ChartsHandler(w, r, chartsTemplate, stravaDb, stravaAccount)
+	})
+	{
+		cache := &strava.BadgeCache{}
+		baseMux.HandleFunc("/running/badge.svg", func(w http.ResponseWriter, r *http.Request) {
+			strava.BadgeHandler(w, r, stravaDb, cache)
+		})
+	}
+	baseMux.HandleFunc("/running/review/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ReviewHandler(w, r, reviewTemplate, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/duplicates/", func(w http.ResponseWriter, r *http.Request) {
+		strava.DuplicatesHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/journal/", func(w http.ResponseWriter, r *http.Request) {
+		strava.JournalHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/plan/", func(w http.ResponseWriter, r *http.Request) {
+		strava.PlanHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/import/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ImportHandler(w, r, stravaDb, stravaAccount)
+	})
+	baseMux.HandleFunc("/running/challenges/", func(w http.ResponseWriter, r *http.Request) {
+		strava.ChallengesHandler(w, r, challengeTemplate, stravaDb, stravaAccount)
+	})
 
 	topMux := http.NewServeMux()
 	topMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -143,11 +272,11 @@ func main() {
 
 	topMux.Handle("/favicon.ico", httpFS(staticFS, "static"))
 
-	var httpHandler http.Handler
+	var httpHandler http.Handler = topMux
 
 	// TODO: in a handler wrapper, redirect http to https (in production only)
 
-	if !inDev {
+	if !*inDev {
 		log.Printf("starting autocert manager with certsDir=%v", *certsDir)
 		if err := os.MkdirAll(*certsDir, 0777); err != nil {
 			log.Fatalf("failed to create certs dir: %s", err)
@@ -190,7 +319,7 @@ func main() {
 	}
 
 	srv := &http.Server{Handler: httpHandler}
-	srv.Addr = ":http"
+	srv.Addr = *httpAddr
 	go func() {
 		<-ctx.Done()
 		if err := srv.Close(); err != nil {
@@ -219,6 +348,7 @@ func makeHTTPServer(mux *http.ServeMux) *http.Server {
 }
 
 type server struct {
-	db   *sql.DB
-	host string
+	db      *storage.DB
+	host    string
+	backups *storage.Backups // nil unless backups are configured
 }
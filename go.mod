@@ -1,6 +1,6 @@
 module github.com/ianrose14/website
 
-go 1.18
+go 1.20
 
 require (
 	github.com/mattn/go-sqlite3 v1.14.17
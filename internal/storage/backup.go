@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backups are named with the (UTC) time they were taken, so that they sort in order.
+const (
+	backupPrefix     = "store-"
+	backupSuffix     = ".sqlite"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// Retention says which backups to keep: the most recent Last backups, plus the newest backup from each of the most
+// recent Daily days and Weekly weeks.  Everything else is deleted.
+type Retention struct {
+	Last   int
+	Daily  int
+	Weekly int
+}
+
+// ParseRetention parses retention rules like "last=8,daily=7,weekly=8"; rules that are left out keep nothing.
+func ParseRetention(s string) (Retention, error) {
+	var r Retention
+	for _, rule := range strings.Split(s, ",") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n < 0 {
+			return r, fmt.Errorf("invalid retention rule %q", rule)
+		}
+
+		switch key {
+		case "last":
+			r.Last = n
+		case "daily":
+			r.Daily = n
+		case "weekly":
+			r.Weekly = n
+		default:
+			return r, fmt.Errorf("unknown retention rule %q", key)
+		}
+	}
+	return r, nil
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("last=%d,daily=%d,weekly=%d", r.Last, r.Daily, r.Weekly)
+}
+
+// Backup is one backup file.
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// ListBackups returns the backups in dir, newest first.  Other files in dir are ignored.
+func ListBackups(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		t, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, name), Time: t})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// expiredBackups returns the backups (given newest first) that the retention rules don't keep.  The newest backup is
+// always kept.
+func expiredBackups(backups []Backup, r Retention) []Backup {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for i, b := range backups {
+		if i == 0 || i < r.Last {
+			keep[b.Path] = true
+		}
+
+		day := b.Time.Format("2006-01-02")
+		if !days[day] && len(days) < r.Daily {
+			days[day] = true
+			keep[b.Path] = true
+		}
+
+		year, week := b.Time.ISOWeek()
+		key := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[key] && len(weeks) < r.Weekly {
+			weeks[key] = true
+			keep[b.Path] = true
+		}
+	}
+
+	var expired []Backup
+	for _, b := range backups {
+		if !keep[b.Path] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// BackupDatabase writes a consistent copy of the (live) database to a new, timestamped file in dir, and returns its
+// path.  The copy is made with VACUUM INTO, so it is also compacted, and it's written under a temporary name first so
+// that a backup that fails part way through is never mistaken for a good one.
+func BackupDatabase(ctx context.Context, db *sql.DB, dir string, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeLayout)+backupSuffix)
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp) // VACUUM INTO refuses to overwrite, so clear out anything left over from a failed backup
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// Backups takes periodic backups of a database, deleting old ones as they expire.
+type Backups struct {
+	Db        *sql.DB
+	Dir       string
+	Interval  time.Duration
+	Retention Retention
+	Logf      func(format string, args ...interface{})
+
+	mu sync.Mutex // one backup at a time
+}
+
+// Run takes a backup every Interval until ctx is done.
+func (b *Backups) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := b.Backup(ctx); err != nil {
+				b.Logf("scheduled backup failed: %s", err)
+			}
+		}
+	}
+}
+
+// Backup takes a backup now, then deletes any that have expired, and returns the new backup's path.
+func (b *Backups) Backup(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := time.Now()
+	path, err := BackupDatabase(ctx, b.Db, b.Dir, start)
+	if err != nil {
+		return "", err
+	}
+	b.Logf("backed up database to %s in %s", path, time.Since(start).Round(time.Millisecond))
+
+	backups, err := ListBackups(b.Dir)
+	if err != nil {
+		return path, fmt.Errorf("failed to list backups: %w", err)
+	}
+	for _, expired := range expiredBackups(backups, b.Retention) {
+		if err := os.Remove(expired.Path); err != nil {
+			b.Logf("failed to delete expired backup: %s", err)
+			continue
+		}
+		b.Logf("deleted expired backup %s", expired.Path)
+	}
+	return path, nil
+}
+
+// checkBackup opens a backup and checks that it is intact, and isn't from a newer version of the schema than this
+// binary knows about.
+func checkBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to check integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return err
+		}
+		if s != "ok" {
+			problems = append(problems, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	status, err := ReadMigrationStatus(ctx, db)
+	if err != nil {
+		return err
+	}
+	if status.Current > status.Latest {
+		return fmt.Errorf("%w: backup is at version %d, but the latest migration here is %d", ErrDatabaseAhead,
+			status.Current, status.Latest)
+	}
+	return nil
+}
+
+// RestoreDatabase replaces the database file with a copy of a backup, once the backup has passed an integrity check.
+// The current database (if any) is kept alongside, renamed with a timestamped ".before-restore" suffix, and its path
+// is returned.  Nothing may be using the database while it is restored, so stop the webapp first.
+func RestoreDatabase(ctx context.Context, backupPath, dbfile string) (string, error) {
+	if err := checkBackup(ctx, backupPath); err != nil {
+		return "", fmt.Errorf("backup %s is unusable: %w", backupPath, err)
+	}
+
+	// copy into place under a temporary name, so that the swap itself is just renames
+	tmp := dbfile + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	var aside string
+	if _, err := os.Stat(dbfile); err == nil {
+		aside = dbfile + ".before-restore-" + time.Now().UTC().Format(backupTimeLayout)
+		if err := os.Rename(dbfile, aside); err != nil {
+			_ = os.Remove(tmp)
+			return "", fmt.Errorf("failed to move current database aside: %w", err)
+		}
+
+		// any write-ahead log belongs to the database that was just moved aside, and must not be applied to the backup
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Rename(dbfile+suffix, aside+suffix); err != nil && !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to move %s aside: %w", dbfile+suffix, err)
+			}
+		}
+	}
+
+	return aside, os.Rename(tmp, dbfile)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
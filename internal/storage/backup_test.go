@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Retention
+		wantErr bool
+	}{
+		{in: "last=8,daily=7,weekly=8", want: Retention{Last: 8, Daily: 7, Weekly: 8}},
+		{in: " weekly=4 , last=2 ", want: Retention{Last: 2, Weekly: 4}},
+		{in: "daily=0,", want: Retention{}},
+		{in: "", want: Retention{}},
+		{in: "last=-1", wantErr: true},
+		{in: "last", wantErr: true},
+		{in: "daily=seven", wantErr: true},
+		{in: "monthly=6", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRetention(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRetention(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRetention(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	if r := (Retention{Last: 8, Daily: 7, Weekly: 8}); r.String() != "last=8,daily=7,weekly=8" {
+		t.Errorf("String() = %q, want the rules it was parsed from", r.String())
+	}
+}
+
+func TestExpiredBackups(t *testing.T) {
+	// named by their times, newest first; the week of May 13th, 2024 starts on a Monday
+	var backups []Backup
+	for _, s := range []string{
+		"2024-05-15 18:00", // 0: Wednesday
+		"2024-05-15 06:00", // 1
+		"2024-05-14 18:00", // 2: Tuesday
+		"2024-05-14 06:00", // 3
+		"2024-05-12 18:00", // 4: Sunday of the week before
+		"2024-05-10 06:00", // 5
+		"2024-05-05 06:00", // 6: the week before that
+		"2024-04-28 06:00", // 7: and the week before that
+	} {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, Backup{Path: s, Time: ts})
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		want      []int // indexes of the expired backups
+	}{
+		{"nothing kept but the newest", Retention{}, []int{1, 2, 3, 4, 5, 6, 7}},
+		{"last", Retention{Last: 3}, []int{3, 4, 5, 6, 7}},
+		{"daily", Retention{Daily: 3}, []int{1, 3, 5, 6, 7}},
+		{"weekly", Retention{Weekly: 3}, []int{1, 2, 3, 5, 7}},
+		{"combined", Retention{Last: 2, Daily: 2, Weekly: 4}, []int{3, 5}},
+		{"everything kept", Retention{Last: 10, Daily: 10, Weekly: 10}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []Backup
+			for _, i := range tt.want {
+				want = append(want, backups[i])
+			}
+			if got := expiredBackups(backups, tt.retention); !reflect.DeepEqual(got, want) {
+				t.Errorf("expiredBackups(%v) = %v, want %v", tt.retention, got, want)
+			}
+		})
+	}
+
+	if got := expiredBackups(nil, Retention{}); got != nil {
+		t.Errorf("expiredBackups(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// migrations are named like 0002_add_something.sql, and are applied in order of their numbers.
+	//go:embed migrations/*.sql
+	migrationsFS embed.FS
+
+	// ErrDatabaseAhead means that the database has had migrations applied that this binary doesn't know about, most
+	// likely by a newer version of it.
+	ErrDatabaseAhead = errors.New("database schema is newer than this binary")
+)
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_time DATE NOT NULL
+)`
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// MigrationStatus describes which migrations have been applied to a database.
+type MigrationStatus struct {
+	Current int // the latest migration that has been applied, or zero for none
+	Latest  int // the latest migration that this binary knows about
+	Pending []Migration
+}
+
+// loadMigrations returns all of the migrations in fsys (under migrations/), in order.  Their versions must run from 1
+// with no gaps, so that a missing file can't be silently skipped.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		prefix, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named like 0001_description.sql", name)
+		}
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: base, sql: string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("expected migration %d but found %s", i+1, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// ReadMigrationStatus returns which migrations have been applied to the database, and which are still to be applied.
+func ReadMigrationStatus(ctx context.Context, db *sql.DB) (*MigrationStatus, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	status := &MigrationStatus{Latest: len(migrations)}
+
+	// a database that has never been migrated has no schema_migrations table yet
+	var tables int
+	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'")
+	if err := row.Scan(&tables); err != nil {
+		return nil, fmt.Errorf("failed to look for schema_migrations table: %w", err)
+	}
+	if tables > 0 {
+		row = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+		if err := row.Scan(&status.Current); err != nil {
+			return nil, fmt.Errorf("failed to read schema version: %w", err)
+		}
+	}
+
+	if status.Current < len(migrations) {
+		status.Pending = migrations[status.Current:]
+	}
+	return status, nil
+}
+
+// MigrateDatabase applies any pending migrations to the database, each in its own transaction, and returns the ones
+// that were applied.  It fails with ErrDatabaseAhead, without changing anything, if the database has migrations that
+// this binary doesn't know about.
+//
+// With dryRun, the pending migrations are instead all applied in a single transaction that is then rolled back, which
+// finds any that would fail without changing the database.
+func MigrateDatabase(ctx context.Context, db *sql.DB, dryRun bool) ([]Migration, error) {
+	status, err := ReadMigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if status.Current > status.Latest {
+		return nil, fmt.Errorf("%w: database is at version %d, but the latest migration here is %d", ErrDatabaseAhead,
+			status.Current, status.Latest)
+	}
+	if len(status.Pending) == 0 {
+		return nil, nil
+	}
+
+	if dryRun {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		for _, m := range status.Pending {
+			if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+				return nil, fmt.Errorf("migration %s failed: %w", m.Name, err)
+			}
+		}
+		return status.Pending, nil
+	}
+
+	if _, err := db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	for i, m := range status.Pending {
+		if err := applyMigration(ctx, db, m); err != nil {
+			return status.Pending[:i], fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+	}
+	return status.Pending, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+
+	// the primary key also guards against another process having applied the same migration in the meantime
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations(version, name, applied_time) VALUES (?,?,?)",
+		m.Version, m.Name, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	tests := []struct {
+		name    string
+		files   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "in order",
+			files: []string{"0001_init.sql", "0002_add_goals.sql", "0003_add_races.sql"},
+			want:  []string{"0001_init", "0002_add_goals", "0003_add_races"},
+		},
+		{
+			name: "by number, not name",
+			files: []string{"1_a.sql", "2_b.sql", "3_c.sql", "4_d.sql", "5_e.sql", "6_f.sql", "7_g.sql", "8_h.sql",
+				"9_i.sql", "10_j.sql"},
+			want: []string{"1_a", "2_b", "3_c", "4_d", "5_e", "6_f", "7_g", "8_h", "9_i", "10_j"},
+		},
+		{
+			name:  "other files ignored",
+			files: []string{"0001_init.sql", "README.md"},
+			want:  []string{"0001_init"},
+		},
+		{
+			name:  "none",
+			files: nil,
+			want:  nil,
+		},
+		{
+			name:    "gap",
+			files:   []string{"0001_init.sql", "0003_add_races.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "not from 1",
+			files:   []string{"0002_add_goals.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate version",
+			files:   []string{"0001_init.sql", "0002_add_goals.sql", "0002_add_races.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "unnumbered",
+			files:   []string{"0001_init.sql", "add_goals.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "zero",
+			files:   []string{"0000_init.sql"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys["migrations/"+name] = file
+			}
+
+			migrations, err := loadMigrations(fsys)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("loadMigrations() = %v, want an error", migrations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadMigrations failed: %s", err)
+			}
+
+			var got []string
+			for i, m := range migrations {
+				if m.Version != i+1 {
+					t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+				}
+				got = append(got, m.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadMigrations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(filepath.Join(t.TempDir(), "test.sqlite"), DefaultSettings())
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close()
+
+	latest, err := loadMigrations(migrationsFS)
+	if err != nil {
+		t.Fatalf("failed to load embedded migrations: %s", err)
+	}
+
+	// a dry run finds what would be applied, but leaves the database alone
+	applied, err := MigrateDatabase(ctx, db.Write, true)
+	if err != nil || len(applied) != len(latest) {
+		t.Fatalf("dry run applied %d migrations (%v), want %d", len(applied), err, len(latest))
+	}
+	status, err := ReadMigrationStatus(ctx, db.Read)
+	if err != nil || status.Current != 0 || len(status.Pending) != len(latest) {
+		t.Fatalf("after a dry run, status is %+v (%v), want nothing applied", status, err)
+	}
+
+	applied, err = MigrateDatabase(ctx, db.Write, false)
+	if err != nil || len(applied) != len(latest) {
+		t.Fatalf("applied %d migrations (%v), want %d", len(applied), err, len(latest))
+	}
+	status, err = ReadMigrationStatus(ctx, db.Read)
+	if err != nil || status.Current != len(latest) || status.Latest != len(latest) || len(status.Pending) != 0 {
+		t.Fatalf("after migrating, status is %+v (%v), want all %d applied", status, err, len(latest))
+	}
+
+	if applied, err := MigrateDatabase(ctx, db.Write, false); err != nil || len(applied) != 0 {
+		t.Errorf("migrating again applied %d migrations (%v), want none", len(applied), err)
+	}
+
+	// a newer binary has been here
+	_, err = db.Write.ExecContext(ctx,
+		"INSERT INTO schema_migrations(version, name, applied_time) VALUES (?, 'future', CURRENT_TIMESTAMP)", len(latest)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateDatabase(ctx, db.Write, false); !errors.Is(err, ErrDatabaseAhead) {
+		t.Errorf("migrating a newer database got error %v, want ErrDatabaseAhead", err)
+	}
+}
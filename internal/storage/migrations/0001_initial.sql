@@ -0,0 +1,131 @@
+-- The schema from before versioned migrations, which is why everything here is IF NOT EXISTS: databases that were
+-- created back then already have these tables, and are brought under migration as-is.
+
+CREATE TABLE IF NOT EXISTS strava_tokens (
+    username TEXT NOT NULL PRIMARY KEY,
+    access_token TEXT NOT NULL,
+    refresh_token TEXT NOT NULL,
+    created_time DATE NOT NULL,
+    expires_at DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_activity_details (
+    activity_id INTEGER NOT NULL PRIMARY KEY,
+    username TEXT NOT NULL,
+    detail TEXT NOT NULL,
+    streams TEXT NOT NULL,
+    fetched_time DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_races (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    name TEXT NOT NULL,
+    race_date DATE NOT NULL,
+    distance_meters REAL NOT NULL,
+    goal_seconds INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_activities (
+    id INTEGER NOT NULL PRIMARY KEY,
+    username TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    data TEXT NOT NULL,
+    updated_time DATE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS strava_activities_by_user ON strava_activities (username, start_date);
+
+CREATE TABLE IF NOT EXISTS strava_sync_state (
+    username TEXT NOT NULL PRIMARY KEY,
+    backfill_cursor DATE NOT NULL,
+    backfill_complete BOOLEAN NOT NULL,
+    updated_time DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_share_settings (
+    username TEXT NOT NULL PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    enabled BOOLEAN NOT NULL,
+    hide_names BOOLEAN NOT NULL,
+    hide_start_times BOOLEAN NOT NULL,
+    updated_time DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_achievements (
+    username TEXT NOT NULL,
+    key TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    activity_id INTEGER NOT NULL,
+    achieved_date DATE NOT NULL,
+    description TEXT NOT NULL,
+    created_time DATE NOT NULL,
+    PRIMARY KEY (username, key)
+);
+
+CREATE TABLE IF NOT EXISTS strava_goals (
+    username TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    target REAL NOT NULL,
+    updated_time DATE NOT NULL,
+    PRIMARY KEY (username, year, kind)
+);
+
+CREATE TABLE IF NOT EXISTS strava_goal_schedules (
+    username TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    period TEXT NOT NULL,
+    weights TEXT NOT NULL,
+    updated_time DATE NOT NULL,
+    PRIMARY KEY (username, year)
+);
+
+CREATE TABLE IF NOT EXISTS strava_duplicate_decisions (
+    username TEXT NOT NULL,
+    activity_id INTEGER NOT NULL,
+    other_id INTEGER NOT NULL,
+    excluded BOOLEAN NOT NULL,
+    updated_time DATE NOT NULL,
+    PRIMARY KEY (username, activity_id, other_id)
+);
+
+CREATE TABLE IF NOT EXISTS strava_journal_entries (
+    username TEXT NOT NULL,
+    activity_id INTEGER NOT NULL,
+    notes TEXT NOT NULL,
+    effort INTEGER NOT NULL,
+    feel INTEGER NOT NULL,
+    tags TEXT NOT NULL,
+    injury TEXT NOT NULL,
+    updated_time DATE NOT NULL,
+    PRIMARY KEY (username, activity_id)
+);
+
+CREATE TABLE IF NOT EXISTS strava_plan_workouts (
+    username TEXT NOT NULL,
+    day DATE NOT NULL,
+    seq INTEGER NOT NULL,
+    workout TEXT NOT NULL,
+    miles REAL NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (username, day, seq)
+);
+
+CREATE TABLE IF NOT EXISTS strava_challenges (
+    id TEXT NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    sport TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    target_miles REAL NOT NULL,
+    created_time DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS strava_challenge_members (
+    challenge_id TEXT NOT NULL,
+    username TEXT NOT NULL,
+    joined_time DATE NOT NULL,
+    PRIMARY KEY (challenge_id, username)
+);
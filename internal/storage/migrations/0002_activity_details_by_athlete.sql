@@ -0,0 +1,19 @@
+-- Cached activity details are keyed by the athlete that fetched them, as well as the activity: athletes can view each
+-- other's public activities, and one athlete's copy mustn't hide the activity from its owner.
+
+CREATE TABLE strava_activity_details_new (
+    activity_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    detail TEXT NOT NULL,
+    streams TEXT NOT NULL,
+    fetched_time DATE NOT NULL,
+    PRIMARY KEY (username, activity_id)
+);
+
+INSERT INTO strava_activity_details_new(activity_id, username, detail, streams, fetched_time)
+    SELECT activity_id, username, detail, streams, fetched_time
+    FROM strava_activity_details;
+
+DROP TABLE strava_activity_details;
+
+ALTER TABLE strava_activity_details_new RENAME TO strava_activity_details;
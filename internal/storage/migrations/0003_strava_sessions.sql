@@ -0,0 +1,9 @@
+-- Sessions sign athletes in on a browser, once they have connected their Strava account.  Only a hash of each
+-- session's cookie is stored, so that the table can't be used to sign in as anyone.
+
+CREATE TABLE strava_sessions (
+    id TEXT NOT NULL PRIMARY KEY,
+    username TEXT NOT NULL,
+    created_time DATE NOT NULL,
+    expires_at DATE NOT NULL
+);
@@ -0,0 +1,21 @@
+-- Activities are keyed by their athlete as well as their id, like cached details (0002): ids in an uploaded archive
+-- aren't checked against Strava, so one athlete's import mustn't be able to replace another's activities.
+
+CREATE TABLE strava_activities_new (
+    id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    data TEXT NOT NULL,
+    updated_time DATE NOT NULL,
+    PRIMARY KEY (username, id)
+);
+
+INSERT INTO strava_activities_new(id, username, start_date, data, updated_time)
+    SELECT id, username, start_date, data, updated_time
+    FROM strava_activities;
+
+DROP TABLE strava_activities;
+
+ALTER TABLE strava_activities_new RENAME TO strava_activities;
+
+CREATE INDEX strava_activities_by_user ON strava_activities (username, start_date);
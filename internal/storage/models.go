@@ -8,6 +8,125 @@ import (
 	"time"
 )
 
+type StravaAchievement struct {
+	Username     string
+	Key          string
+	Kind         string
+	ActivityID   int64
+	AchievedDate time.Time
+	Description  string
+	CreatedTime  time.Time
+}
+
+type StravaActivity struct {
+	ID          int64
+	Username    string
+	StartDate   time.Time
+	Data        string
+	UpdatedTime time.Time
+}
+
+type StravaActivityDetail struct {
+	ActivityID  int64
+	Username    string
+	Detail      string
+	Streams     string
+	FetchedTime time.Time
+}
+
+type StravaChallenge struct {
+	ID          string
+	Name        string
+	Owner       string
+	Sport       string
+	StartDate   time.Time
+	EndDate     time.Time
+	TargetMiles float64
+	CreatedTime time.Time
+}
+
+type StravaChallengeMember struct {
+	ChallengeID string
+	Username    string
+	JoinedTime  time.Time
+}
+
+type StravaDuplicateDecision struct {
+	Username    string
+	ActivityID  int64
+	OtherID     int64
+	Excluded    bool
+	UpdatedTime time.Time
+}
+
+type StravaGoal struct {
+	Username    string
+	Year        int64
+	Kind        string
+	Target      float64
+	UpdatedTime time.Time
+}
+
+type StravaGoalSchedule struct {
+	Username    string
+	Year        int64
+	Period      string
+	Weights     string
+	UpdatedTime time.Time
+}
+
+type StravaJournalEntry struct {
+	Username    string
+	ActivityID  int64
+	Notes       string
+	Effort      int64
+	Feel        int64
+	Tags        string
+	Injury      string
+	UpdatedTime time.Time
+}
+
+type StravaPlanWorkout struct {
+	Username    string
+	Day         time.Time
+	Seq         int64
+	Workout     string
+	Miles       float64
+	Description string
+}
+
+type StravaRace struct {
+	ID             int64
+	Username       string
+	Name           string
+	RaceDate       time.Time
+	DistanceMeters float64
+	GoalSeconds    int64
+}
+
+type StravaSession struct {
+	ID          string
+	Username    string
+	CreatedTime time.Time
+	ExpiresAt   time.Time
+}
+
+type StravaShareSetting struct {
+	Username       string
+	Slug           string
+	Enabled        bool
+	HideNames      bool
+	HideStartTimes bool
+	UpdatedTime    time.Time
+}
+
+type StravaSyncState struct {
+	Username         string
+	BackfillCursor   time.Time
+	BackfillComplete bool
+	UpdatedTime      time.Time
+}
+
 type StravaToken struct {
 	Username     string
 	AccessToken  string
@@ -5,3 +5,178 @@ INSERT OR REPLACE INTO strava_tokens(username, access_token, refresh_token, crea
 SELECT access_token, refresh_token, created_time, expires_at
     FROM strava_tokens
     WHERE username=?;
+
+-- name: InsertActivityDetails :exec
+INSERT OR REPLACE INTO strava_activity_details(activity_id, username, detail, streams, fetched_time) VALUES (?,?,?,?,?);
+
+-- name: FetchActivityDetails :one
+SELECT detail, streams, fetched_time
+    FROM strava_activity_details
+    WHERE username=? AND activity_id=?;
+
+-- name: InsertRace :exec
+INSERT INTO strava_races(username, name, race_date, distance_meters, goal_seconds) VALUES (?,?,?,?,?);
+
+-- name: ListRaces :many
+SELECT id, username, name, race_date, distance_meters, goal_seconds
+    FROM strava_races
+    WHERE username=?
+    ORDER BY race_date;
+
+-- name: DeleteRace :exec
+DELETE FROM strava_races
+    WHERE id=? AND username=?;
+
+-- name: UpsertActivity :exec
+INSERT OR REPLACE INTO strava_activities(id, username, start_date, data, updated_time) VALUES (?,?,?,?,?);
+
+-- name: CountActivity :one
+SELECT COUNT(*)
+    FROM strava_activities
+    WHERE id=? AND username=?;
+
+-- name: ListActivities :many
+SELECT id, start_date, data
+    FROM strava_activities
+    WHERE username=? AND start_date >= ? AND start_date < ?
+    ORDER BY start_date;
+
+-- name: DeleteActivity :exec
+DELETE FROM strava_activities
+    WHERE id=? AND username=?;
+
+-- name: FetchSyncState :one
+SELECT backfill_cursor, backfill_complete, updated_time
+    FROM strava_sync_state
+    WHERE username=?;
+
+-- name: UpsertSyncState :exec
+INSERT OR REPLACE INTO strava_sync_state(username, backfill_cursor, backfill_complete, updated_time) VALUES (?,?,?,?);
+
+-- name: FetchShareSettings :one
+SELECT username, slug, enabled, hide_names, hide_start_times, updated_time
+    FROM strava_share_settings
+    WHERE username=?;
+
+-- name: FetchShareSettingsBySlug :one
+SELECT username, slug, enabled, hide_names, hide_start_times, updated_time
+    FROM strava_share_settings
+    WHERE slug=?;
+
+-- name: UpsertShareSettings :exec
+INSERT OR REPLACE INTO strava_share_settings(username, slug, enabled, hide_names, hide_start_times, updated_time) VALUES (?,?,?,?,?,?);
+
+-- name: InsertAchievement :execrows
+INSERT OR IGNORE INTO strava_achievements(username, key, kind, activity_id, achieved_date, description, created_time) VALUES (?,?,?,?,?,?,?);
+
+-- name: ListAchievements :many
+SELECT username, key, kind, activity_id, achieved_date, description, created_time
+    FROM strava_achievements
+    WHERE username=?
+    ORDER BY achieved_date DESC;
+
+-- name: DeleteAchievement :exec
+DELETE FROM strava_achievements
+    WHERE username=? AND key=?;
+
+-- name: ListGoals :many
+SELECT username, year, kind, target, updated_time
+    FROM strava_goals
+    WHERE username=? AND year=?;
+
+-- name: UpsertGoal :exec
+INSERT OR REPLACE INTO strava_goals(username, year, kind, target, updated_time) VALUES (?,?,?,?,?);
+
+-- name: DeleteGoals :exec
+DELETE FROM strava_goals
+    WHERE username=? AND year=?;
+
+-- name: FetchGoalSchedule :one
+SELECT username, year, period, weights, updated_time
+    FROM strava_goal_schedules
+    WHERE username=? AND year=?;
+
+-- name: UpsertGoalSchedule :exec
+INSERT OR REPLACE INTO strava_goal_schedules(username, year, period, weights, updated_time) VALUES (?,?,?,?,?);
+
+-- name: DeleteGoalSchedule :exec
+DELETE FROM strava_goal_schedules
+    WHERE username=? AND year=?;
+
+-- name: ListDuplicateDecisions :many
+SELECT username, activity_id, other_id, excluded, updated_time
+    FROM strava_duplicate_decisions
+    WHERE username=?;
+
+-- name: UpsertDuplicateDecision :exec
+INSERT OR REPLACE INTO strava_duplicate_decisions(username, activity_id, other_id, excluded, updated_time) VALUES (?,?,?,?,?);
+
+-- name: DeleteDuplicateDecision :exec
+DELETE FROM strava_duplicate_decisions
+    WHERE username=? AND activity_id=? AND other_id=?;
+
+-- name: FetchJournalEntry :one
+SELECT username, activity_id, notes, effort, feel, tags, injury, updated_time
+    FROM strava_journal_entries
+    WHERE username=? AND activity_id=?;
+
+-- name: ListJournalEntries :many
+SELECT username, activity_id, notes, effort, feel, tags, injury, updated_time
+    FROM strava_journal_entries
+    WHERE username=?;
+
+-- name: UpsertJournalEntry :exec
+INSERT OR REPLACE INTO strava_journal_entries(username, activity_id, notes, effort, feel, tags, injury, updated_time) VALUES (?,?,?,?,?,?,?,?);
+
+-- name: DeleteJournalEntry :exec
+DELETE FROM strava_journal_entries
+    WHERE username=? AND activity_id=?;
+
+-- name: ListPlanWorkouts :many
+SELECT username, day, seq, workout, miles, description
+    FROM strava_plan_workouts
+    WHERE username=?
+    ORDER BY day, seq;
+
+-- name: InsertPlanWorkout :exec
+INSERT INTO strava_plan_workouts(username, day, seq, workout, miles, description) VALUES (?,?,?,?,?,?);
+
+-- name: DeletePlanWorkouts :exec
+DELETE FROM strava_plan_workouts
+    WHERE username=?;
+
+-- name: InsertChallenge :exec
+INSERT INTO strava_challenges(id, name, owner, sport, start_date, end_date, target_miles, created_time) VALUES (?,?,?,?,?,?,?,?);
+
+-- name: FetchChallenge :one
+SELECT id, name, owner, sport, start_date, end_date, target_miles, created_time
+    FROM strava_challenges
+    WHERE id=?;
+
+-- name: ListChallengesByMember :many
+SELECT c.id, c.name, c.owner, c.sport, c.start_date, c.end_date, c.target_miles, c.created_time
+    FROM strava_challenges c
+    JOIN strava_challenge_members m ON m.challenge_id = c.id
+    WHERE m.username=?
+    ORDER BY c.start_date DESC;
+
+-- name: InsertChallengeMember :exec
+INSERT OR IGNORE INTO strava_challenge_members(challenge_id, username, joined_time) VALUES (?,?,?);
+
+-- name: ListChallengeMembers :many
+SELECT challenge_id, username, joined_time
+    FROM strava_challenge_members
+    WHERE challenge_id=?
+    ORDER BY joined_time;
+
+-- name: InsertSession :exec
+INSERT INTO strava_sessions(id, username, created_time, expires_at) VALUES (?,?,?,?);
+
+-- name: FetchSession :one
+SELECT id, username, created_time, expires_at
+    FROM strava_sessions
+    WHERE id=?;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM strava_sessions
+    WHERE expires_at < ?;
@@ -10,6 +10,309 @@ import (
 	"time"
 )
 
+const countActivity = `-- name: CountActivity :one
+SELECT COUNT(*)
+    FROM strava_activities
+    WHERE id=? AND username=?
+`
+
+type CountActivityParams struct {
+	ID       int64
+	Username string
+}
+
+func (q *Queries) CountActivity(ctx context.Context, arg CountActivityParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countActivity, arg.ID, arg.Username)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteAchievement = `-- name: DeleteAchievement :exec
+DELETE FROM strava_achievements
+    WHERE username=? AND key=?
+`
+
+type DeleteAchievementParams struct {
+	Username string
+	Key      string
+}
+
+func (q *Queries) DeleteAchievement(ctx context.Context, arg DeleteAchievementParams) error {
+	_, err := q.db.ExecContext(ctx, deleteAchievement, arg.Username, arg.Key)
+	return err
+}
+
+const deleteActivity = `-- name: DeleteActivity :exec
+DELETE FROM strava_activities
+    WHERE id=? AND username=?
+`
+
+type DeleteActivityParams struct {
+	ID       int64
+	Username string
+}
+
+func (q *Queries) DeleteActivity(ctx context.Context, arg DeleteActivityParams) error {
+	_, err := q.db.ExecContext(ctx, deleteActivity, arg.ID, arg.Username)
+	return err
+}
+
+const deleteDuplicateDecision = `-- name: DeleteDuplicateDecision :exec
+DELETE FROM strava_duplicate_decisions
+    WHERE username=? AND activity_id=? AND other_id=?
+`
+
+type DeleteDuplicateDecisionParams struct {
+	Username   string
+	ActivityID int64
+	OtherID    int64
+}
+
+func (q *Queries) DeleteDuplicateDecision(ctx context.Context, arg DeleteDuplicateDecisionParams) error {
+	_, err := q.db.ExecContext(ctx, deleteDuplicateDecision, arg.Username, arg.ActivityID, arg.OtherID)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM strava_sessions
+    WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredSessions, expiresAt)
+	return err
+}
+
+const deleteGoalSchedule = `-- name: DeleteGoalSchedule :exec
+DELETE FROM strava_goal_schedules
+    WHERE username=? AND year=?
+`
+
+type DeleteGoalScheduleParams struct {
+	Username string
+	Year     int64
+}
+
+func (q *Queries) DeleteGoalSchedule(ctx context.Context, arg DeleteGoalScheduleParams) error {
+	_, err := q.db.ExecContext(ctx, deleteGoalSchedule, arg.Username, arg.Year)
+	return err
+}
+
+const deleteGoals = `-- name: DeleteGoals :exec
+DELETE FROM strava_goals
+    WHERE username=? AND year=?
+`
+
+type DeleteGoalsParams struct {
+	Username string
+	Year     int64
+}
+
+func (q *Queries) DeleteGoals(ctx context.Context, arg DeleteGoalsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteGoals, arg.Username, arg.Year)
+	return err
+}
+
+const deleteJournalEntry = `-- name: DeleteJournalEntry :exec
+DELETE FROM strava_journal_entries
+    WHERE username=? AND activity_id=?
+`
+
+type DeleteJournalEntryParams struct {
+	Username   string
+	ActivityID int64
+}
+
+func (q *Queries) DeleteJournalEntry(ctx context.Context, arg DeleteJournalEntryParams) error {
+	_, err := q.db.ExecContext(ctx, deleteJournalEntry, arg.Username, arg.ActivityID)
+	return err
+}
+
+const deletePlanWorkouts = `-- name: DeletePlanWorkouts :exec
+DELETE FROM strava_plan_workouts
+    WHERE username=?
+`
+
+func (q *Queries) DeletePlanWorkouts(ctx context.Context, username string) error {
+	_, err := q.db.ExecContext(ctx, deletePlanWorkouts, username)
+	return err
+}
+
+const deleteRace = `-- name: DeleteRace :exec
+DELETE FROM strava_races
+    WHERE id=? AND username=?
+`
+
+type DeleteRaceParams struct {
+	ID       int64
+	Username string
+}
+
+func (q *Queries) DeleteRace(ctx context.Context, arg DeleteRaceParams) error {
+	_, err := q.db.ExecContext(ctx, deleteRace, arg.ID, arg.Username)
+	return err
+}
+
+const fetchActivityDetails = `-- name: FetchActivityDetails :one
+SELECT detail, streams, fetched_time
+    FROM strava_activity_details
+    WHERE username=? AND activity_id=?
+`
+
+type FetchActivityDetailsParams struct {
+	Username   string
+	ActivityID int64
+}
+
+type FetchActivityDetailsRow struct {
+	Detail      string
+	Streams     string
+	FetchedTime time.Time
+}
+
+func (q *Queries) FetchActivityDetails(ctx context.Context, arg FetchActivityDetailsParams) (FetchActivityDetailsRow, error) {
+	row := q.db.QueryRowContext(ctx, fetchActivityDetails, arg.Username, arg.ActivityID)
+	var i FetchActivityDetailsRow
+	err := row.Scan(
+		&i.Detail,
+		&i.Streams,
+		&i.FetchedTime,
+	)
+	return i, err
+}
+
+const fetchChallenge = `-- name: FetchChallenge :one
+SELECT id, name, owner, sport, start_date, end_date, target_miles, created_time
+    FROM strava_challenges
+    WHERE id=?
+`
+
+func (q *Queries) FetchChallenge(ctx context.Context, id string) (StravaChallenge, error) {
+	row := q.db.QueryRowContext(ctx, fetchChallenge, id)
+	var i StravaChallenge
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Sport,
+		&i.StartDate,
+		&i.EndDate,
+		&i.TargetMiles,
+		&i.CreatedTime,
+	)
+	return i, err
+}
+
+const fetchGoalSchedule = `-- name: FetchGoalSchedule :one
+SELECT username, year, period, weights, updated_time
+    FROM strava_goal_schedules
+    WHERE username=? AND year=?
+`
+
+type FetchGoalScheduleParams struct {
+	Username string
+	Year     int64
+}
+
+func (q *Queries) FetchGoalSchedule(ctx context.Context, arg FetchGoalScheduleParams) (StravaGoalSchedule, error) {
+	row := q.db.QueryRowContext(ctx, fetchGoalSchedule, arg.Username, arg.Year)
+	var i StravaGoalSchedule
+	err := row.Scan(
+		&i.Username,
+		&i.Year,
+		&i.Period,
+		&i.Weights,
+		&i.UpdatedTime,
+	)
+	return i, err
+}
+
+const fetchJournalEntry = `-- name: FetchJournalEntry :one
+SELECT username, activity_id, notes, effort, feel, tags, injury, updated_time
+    FROM strava_journal_entries
+    WHERE username=? AND activity_id=?
+`
+
+type FetchJournalEntryParams struct {
+	Username   string
+	ActivityID int64
+}
+
+func (q *Queries) FetchJournalEntry(ctx context.Context, arg FetchJournalEntryParams) (StravaJournalEntry, error) {
+	row := q.db.QueryRowContext(ctx, fetchJournalEntry, arg.Username, arg.ActivityID)
+	var i StravaJournalEntry
+	err := row.Scan(
+		&i.Username,
+		&i.ActivityID,
+		&i.Notes,
+		&i.Effort,
+		&i.Feel,
+		&i.Tags,
+		&i.Injury,
+		&i.UpdatedTime,
+	)
+	return i, err
+}
+
+const fetchSession = `-- name: FetchSession :one
+SELECT id, username, created_time, expires_at
+    FROM strava_sessions
+    WHERE id=?
+`
+
+func (q *Queries) FetchSession(ctx context.Context, id string) (StravaSession, error) {
+	row := q.db.QueryRowContext(ctx, fetchSession, id)
+	var i StravaSession
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.CreatedTime,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const fetchShareSettings = `-- name: FetchShareSettings :one
+SELECT username, slug, enabled, hide_names, hide_start_times, updated_time
+    FROM strava_share_settings
+    WHERE username=?
+`
+
+func (q *Queries) FetchShareSettings(ctx context.Context, username string) (StravaShareSetting, error) {
+	row := q.db.QueryRowContext(ctx, fetchShareSettings, username)
+	var i StravaShareSetting
+	err := row.Scan(
+		&i.Username,
+		&i.Slug,
+		&i.Enabled,
+		&i.HideNames,
+		&i.HideStartTimes,
+		&i.UpdatedTime,
+	)
+	return i, err
+}
+
+const fetchShareSettingsBySlug = `-- name: FetchShareSettingsBySlug :one
+SELECT username, slug, enabled, hide_names, hide_start_times, updated_time
+    FROM strava_share_settings
+    WHERE slug=?
+`
+
+func (q *Queries) FetchShareSettingsBySlug(ctx context.Context, slug string) (StravaShareSetting, error) {
+	row := q.db.QueryRowContext(ctx, fetchShareSettingsBySlug, slug)
+	var i StravaShareSetting
+	err := row.Scan(
+		&i.Username,
+		&i.Slug,
+		&i.Enabled,
+		&i.HideNames,
+		&i.HideStartTimes,
+		&i.UpdatedTime,
+	)
+	return i, err
+}
+
 const fetchStravaTokens = `-- name: FetchStravaTokens :one
 SELECT access_token, refresh_token, created_time, expires_at
     FROM strava_tokens
@@ -35,6 +338,191 @@ func (q *Queries) FetchStravaTokens(ctx context.Context, username string) (Fetch
 	return i, err
 }
 
+const fetchSyncState = `-- name: FetchSyncState :one
+SELECT backfill_cursor, backfill_complete, updated_time
+    FROM strava_sync_state
+    WHERE username=?
+`
+
+type FetchSyncStateRow struct {
+	BackfillCursor   time.Time
+	BackfillComplete bool
+	UpdatedTime      time.Time
+}
+
+func (q *Queries) FetchSyncState(ctx context.Context, username string) (FetchSyncStateRow, error) {
+	row := q.db.QueryRowContext(ctx, fetchSyncState, username)
+	var i FetchSyncStateRow
+	err := row.Scan(&i.BackfillCursor, &i.BackfillComplete, &i.UpdatedTime)
+	return i, err
+}
+
+const insertAchievement = `-- name: InsertAchievement :execrows
+INSERT OR IGNORE INTO strava_achievements(username, key, kind, activity_id, achieved_date, description, created_time) VALUES (?,?,?,?,?,?,?)
+`
+
+type InsertAchievementParams struct {
+	Username     string
+	Key          string
+	Kind         string
+	ActivityID   int64
+	AchievedDate time.Time
+	Description  string
+	CreatedTime  time.Time
+}
+
+func (q *Queries) InsertAchievement(ctx context.Context, arg InsertAchievementParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertAchievement,
+		arg.Username,
+		arg.Key,
+		arg.Kind,
+		arg.ActivityID,
+		arg.AchievedDate,
+		arg.Description,
+		arg.CreatedTime,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const insertActivityDetails = `-- name: InsertActivityDetails :exec
+INSERT OR REPLACE INTO strava_activity_details(activity_id, username, detail, streams, fetched_time) VALUES (?,?,?,?,?)
+`
+
+type InsertActivityDetailsParams struct {
+	ActivityID  int64
+	Username    string
+	Detail      string
+	Streams     string
+	FetchedTime time.Time
+}
+
+func (q *Queries) InsertActivityDetails(ctx context.Context, arg InsertActivityDetailsParams) error {
+	_, err := q.db.ExecContext(ctx, insertActivityDetails,
+		arg.ActivityID,
+		arg.Username,
+		arg.Detail,
+		arg.Streams,
+		arg.FetchedTime,
+	)
+	return err
+}
+
+const insertChallenge = `-- name: InsertChallenge :exec
+INSERT INTO strava_challenges(id, name, owner, sport, start_date, end_date, target_miles, created_time) VALUES (?,?,?,?,?,?,?,?)
+`
+
+type InsertChallengeParams struct {
+	ID          string
+	Name        string
+	Owner       string
+	Sport       string
+	StartDate   time.Time
+	EndDate     time.Time
+	TargetMiles float64
+	CreatedTime time.Time
+}
+
+func (q *Queries) InsertChallenge(ctx context.Context, arg InsertChallengeParams) error {
+	_, err := q.db.ExecContext(ctx, insertChallenge,
+		arg.ID,
+		arg.Name,
+		arg.Owner,
+		arg.Sport,
+		arg.StartDate,
+		arg.EndDate,
+		arg.TargetMiles,
+		arg.CreatedTime,
+	)
+	return err
+}
+
+const insertChallengeMember = `-- name: InsertChallengeMember :exec
+INSERT OR IGNORE INTO strava_challenge_members(challenge_id, username, joined_time) VALUES (?,?,?)
+`
+
+type InsertChallengeMemberParams struct {
+	ChallengeID string
+	Username    string
+	JoinedTime  time.Time
+}
+
+func (q *Queries) InsertChallengeMember(ctx context.Context, arg InsertChallengeMemberParams) error {
+	_, err := q.db.ExecContext(ctx, insertChallengeMember, arg.ChallengeID, arg.Username, arg.JoinedTime)
+	return err
+}
+
+const insertPlanWorkout = `-- name: InsertPlanWorkout :exec
+INSERT INTO strava_plan_workouts(username, day, seq, workout, miles, description) VALUES (?,?,?,?,?,?)
+`
+
+type InsertPlanWorkoutParams struct {
+	Username    string
+	Day         time.Time
+	Seq         int64
+	Workout     string
+	Miles       float64
+	Description string
+}
+
+func (q *Queries) InsertPlanWorkout(ctx context.Context, arg InsertPlanWorkoutParams) error {
+	_, err := q.db.ExecContext(ctx, insertPlanWorkout,
+		arg.Username,
+		arg.Day,
+		arg.Seq,
+		arg.Workout,
+		arg.Miles,
+		arg.Description,
+	)
+	return err
+}
+
+const insertRace = `-- name: InsertRace :exec
+INSERT INTO strava_races(username, name, race_date, distance_meters, goal_seconds) VALUES (?,?,?,?,?)
+`
+
+type InsertRaceParams struct {
+	Username       string
+	Name           string
+	RaceDate       time.Time
+	DistanceMeters float64
+	GoalSeconds    int64
+}
+
+func (q *Queries) InsertRace(ctx context.Context, arg InsertRaceParams) error {
+	_, err := q.db.ExecContext(ctx, insertRace,
+		arg.Username,
+		arg.Name,
+		arg.RaceDate,
+		arg.DistanceMeters,
+		arg.GoalSeconds,
+	)
+	return err
+}
+
+const insertSession = `-- name: InsertSession :exec
+INSERT INTO strava_sessions(id, username, created_time, expires_at) VALUES (?,?,?,?)
+`
+
+type InsertSessionParams struct {
+	ID          string
+	Username    string
+	CreatedTime time.Time
+	ExpiresAt   time.Time
+}
+
+func (q *Queries) InsertSession(ctx context.Context, arg InsertSessionParams) error {
+	_, err := q.db.ExecContext(ctx, insertSession,
+		arg.ID,
+		arg.Username,
+		arg.CreatedTime,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const insertStravaTokens = `-- name: InsertStravaTokens :exec
 INSERT OR REPLACE INTO strava_tokens(username, access_token, refresh_token, created_time, expires_at) VALUES (?,?,?,?,?)
 `
@@ -57,3 +545,511 @@ func (q *Queries) InsertStravaTokens(ctx context.Context, arg InsertStravaTokens
 	)
 	return err
 }
+
+const listAchievements = `-- name: ListAchievements :many
+SELECT username, key, kind, activity_id, achieved_date, description, created_time
+    FROM strava_achievements
+    WHERE username=?
+    ORDER BY achieved_date DESC
+`
+
+func (q *Queries) ListAchievements(ctx context.Context, username string) ([]StravaAchievement, error) {
+	rows, err := q.db.QueryContext(ctx, listAchievements, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaAchievement
+	for rows.Next() {
+		var i StravaAchievement
+		if err := rows.Scan(
+			&i.Username,
+			&i.Key,
+			&i.Kind,
+			&i.ActivityID,
+			&i.AchievedDate,
+			&i.Description,
+			&i.CreatedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActivities = `-- name: ListActivities :many
+SELECT id, start_date, data
+    FROM strava_activities
+    WHERE username=? AND start_date >= ? AND start_date < ?
+    ORDER BY start_date
+`
+
+type ListActivitiesParams struct {
+	Username    string
+	StartDate   time.Time
+	StartDate_2 time.Time
+}
+
+type ListActivitiesRow struct {
+	ID        int64
+	StartDate time.Time
+	Data      string
+}
+
+func (q *Queries) ListActivities(ctx context.Context, arg ListActivitiesParams) ([]ListActivitiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActivities, arg.Username, arg.StartDate, arg.StartDate_2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActivitiesRow
+	for rows.Next() {
+		var i ListActivitiesRow
+		if err := rows.Scan(&i.ID, &i.StartDate, &i.Data); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChallengeMembers = `-- name: ListChallengeMembers :many
+SELECT challenge_id, username, joined_time
+    FROM strava_challenge_members
+    WHERE challenge_id=?
+    ORDER BY joined_time
+`
+
+func (q *Queries) ListChallengeMembers(ctx context.Context, challengeID string) ([]StravaChallengeMember, error) {
+	rows, err := q.db.QueryContext(ctx, listChallengeMembers, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaChallengeMember
+	for rows.Next() {
+		var i StravaChallengeMember
+		if err := rows.Scan(
+			&i.ChallengeID,
+			&i.Username,
+			&i.JoinedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChallengesByMember = `-- name: ListChallengesByMember :many
+SELECT c.id, c.name, c.owner, c.sport, c.start_date, c.end_date, c.target_miles, c.created_time
+    FROM strava_challenges c
+    JOIN strava_challenge_members m ON m.challenge_id = c.id
+    WHERE m.username=?
+    ORDER BY c.start_date DESC
+`
+
+func (q *Queries) ListChallengesByMember(ctx context.Context, username string) ([]StravaChallenge, error) {
+	rows, err := q.db.QueryContext(ctx, listChallengesByMember, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaChallenge
+	for rows.Next() {
+		var i StravaChallenge
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Owner,
+			&i.Sport,
+			&i.StartDate,
+			&i.EndDate,
+			&i.TargetMiles,
+			&i.CreatedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDuplicateDecisions = `-- name: ListDuplicateDecisions :many
+SELECT username, activity_id, other_id, excluded, updated_time
+    FROM strava_duplicate_decisions
+    WHERE username=?
+`
+
+func (q *Queries) ListDuplicateDecisions(ctx context.Context, username string) ([]StravaDuplicateDecision, error) {
+	rows, err := q.db.QueryContext(ctx, listDuplicateDecisions, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaDuplicateDecision
+	for rows.Next() {
+		var i StravaDuplicateDecision
+		if err := rows.Scan(
+			&i.Username,
+			&i.ActivityID,
+			&i.OtherID,
+			&i.Excluded,
+			&i.UpdatedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGoals = `-- name: ListGoals :many
+SELECT username, year, kind, target, updated_time
+    FROM strava_goals
+    WHERE username=? AND year=?
+`
+
+type ListGoalsParams struct {
+	Username string
+	Year     int64
+}
+
+func (q *Queries) ListGoals(ctx context.Context, arg ListGoalsParams) ([]StravaGoal, error) {
+	rows, err := q.db.QueryContext(ctx, listGoals, arg.Username, arg.Year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaGoal
+	for rows.Next() {
+		var i StravaGoal
+		if err := rows.Scan(
+			&i.Username,
+			&i.Year,
+			&i.Kind,
+			&i.Target,
+			&i.UpdatedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJournalEntries = `-- name: ListJournalEntries :many
+SELECT username, activity_id, notes, effort, feel, tags, injury, updated_time
+    FROM strava_journal_entries
+    WHERE username=?
+`
+
+func (q *Queries) ListJournalEntries(ctx context.Context, username string) ([]StravaJournalEntry, error) {
+	rows, err := q.db.QueryContext(ctx, listJournalEntries, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaJournalEntry
+	for rows.Next() {
+		var i StravaJournalEntry
+		if err := rows.Scan(
+			&i.Username,
+			&i.ActivityID,
+			&i.Notes,
+			&i.Effort,
+			&i.Feel,
+			&i.Tags,
+			&i.Injury,
+			&i.UpdatedTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPlanWorkouts = `-- name: ListPlanWorkouts :many
+SELECT username, day, seq, workout, miles, description
+    FROM strava_plan_workouts
+    WHERE username=?
+    ORDER BY day, seq
+`
+
+func (q *Queries) ListPlanWorkouts(ctx context.Context, username string) ([]StravaPlanWorkout, error) {
+	rows, err := q.db.QueryContext(ctx, listPlanWorkouts, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaPlanWorkout
+	for rows.Next() {
+		var i StravaPlanWorkout
+		if err := rows.Scan(
+			&i.Username,
+			&i.Day,
+			&i.Seq,
+			&i.Workout,
+			&i.Miles,
+			&i.Description,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRaces = `-- name: ListRaces :many
+SELECT id, username, name, race_date, distance_meters, goal_seconds
+    FROM strava_races
+    WHERE username=?
+    ORDER BY race_date
+`
+
+func (q *Queries) ListRaces(ctx context.Context, username string) ([]StravaRace, error) {
+	rows, err := q.db.QueryContext(ctx, listRaces, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StravaRace
+	for rows.Next() {
+		var i StravaRace
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Name,
+			&i.RaceDate,
+			&i.DistanceMeters,
+			&i.GoalSeconds,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upsertActivity = `-- name: UpsertActivity :exec
+INSERT OR REPLACE INTO strava_activities(id, username, start_date, data, updated_time) VALUES (?,?,?,?,?)
+`
+
+type UpsertActivityParams struct {
+	ID          int64
+	Username    string
+	StartDate   time.Time
+	Data        string
+	UpdatedTime time.Time
+}
+
+func (q *Queries) UpsertActivity(ctx context.Context, arg UpsertActivityParams) error {
+	_, err := q.db.ExecContext(ctx, upsertActivity,
+		arg.ID,
+		arg.Username,
+		arg.StartDate,
+		arg.Data,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertDuplicateDecision = `-- name: UpsertDuplicateDecision :exec
+INSERT OR REPLACE INTO strava_duplicate_decisions(username, activity_id, other_id, excluded, updated_time) VALUES (?,?,?,?,?)
+`
+
+type UpsertDuplicateDecisionParams struct {
+	Username    string
+	ActivityID  int64
+	OtherID     int64
+	Excluded    bool
+	UpdatedTime time.Time
+}
+
+func (q *Queries) UpsertDuplicateDecision(ctx context.Context, arg UpsertDuplicateDecisionParams) error {
+	_, err := q.db.ExecContext(ctx, upsertDuplicateDecision,
+		arg.Username,
+		arg.ActivityID,
+		arg.OtherID,
+		arg.Excluded,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertGoal = `-- name: UpsertGoal :exec
+INSERT OR REPLACE INTO strava_goals(username, year, kind, target, updated_time) VALUES (?,?,?,?,?)
+`
+
+type UpsertGoalParams struct {
+	Username    string
+	Year        int64
+	Kind        string
+	Target      float64
+	UpdatedTime time.Time
+}
+
+func (q *Queries) UpsertGoal(ctx context.Context, arg UpsertGoalParams) error {
+	_, err := q.db.ExecContext(ctx, upsertGoal,
+		arg.Username,
+		arg.Year,
+		arg.Kind,
+		arg.Target,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertGoalSchedule = `-- name: UpsertGoalSchedule :exec
+INSERT OR REPLACE INTO strava_goal_schedules(username, year, period, weights, updated_time) VALUES (?,?,?,?,?)
+`
+
+type UpsertGoalScheduleParams struct {
+	Username    string
+	Year        int64
+	Period      string
+	Weights     string
+	UpdatedTime time.Time
+}
+
+func (q *Queries) UpsertGoalSchedule(ctx context.Context, arg UpsertGoalScheduleParams) error {
+	_, err := q.db.ExecContext(ctx, upsertGoalSchedule,
+		arg.Username,
+		arg.Year,
+		arg.Period,
+		arg.Weights,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertJournalEntry = `-- name: UpsertJournalEntry :exec
+INSERT OR REPLACE INTO strava_journal_entries(username, activity_id, notes, effort, feel, tags, injury, updated_time) VALUES (?,?,?,?,?,?,?,?)
+`
+
+type UpsertJournalEntryParams struct {
+	Username    string
+	ActivityID  int64
+	Notes       string
+	Effort      int64
+	Feel        int64
+	Tags        string
+	Injury      string
+	UpdatedTime time.Time
+}
+
+func (q *Queries) UpsertJournalEntry(ctx context.Context, arg UpsertJournalEntryParams) error {
+	_, err := q.db.ExecContext(ctx, upsertJournalEntry,
+		arg.Username,
+		arg.ActivityID,
+		arg.Notes,
+		arg.Effort,
+		arg.Feel,
+		arg.Tags,
+		arg.Injury,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertShareSettings = `-- name: UpsertShareSettings :exec
+INSERT OR REPLACE INTO strava_share_settings(username, slug, enabled, hide_names, hide_start_times, updated_time) VALUES (?,?,?,?,?,?)
+`
+
+type UpsertShareSettingsParams struct {
+	Username       string
+	Slug           string
+	Enabled        bool
+	HideNames      bool
+	HideStartTimes bool
+	UpdatedTime    time.Time
+}
+
+func (q *Queries) UpsertShareSettings(ctx context.Context, arg UpsertShareSettingsParams) error {
+	_, err := q.db.ExecContext(ctx, upsertShareSettings,
+		arg.Username,
+		arg.Slug,
+		arg.Enabled,
+		arg.HideNames,
+		arg.HideStartTimes,
+		arg.UpdatedTime,
+	)
+	return err
+}
+
+const upsertSyncState = `-- name: UpsertSyncState :exec
+INSERT OR REPLACE INTO strava_sync_state(username, backfill_cursor, backfill_complete, updated_time) VALUES (?,?,?,?)
+`
+
+type UpsertSyncStateParams struct {
+	Username         string
+	BackfillCursor   time.Time
+	BackfillComplete bool
+	UpdatedTime      time.Time
+}
+
+func (q *Queries) UpsertSyncState(ctx context.Context, arg UpsertSyncStateParams) error {
+	_, err := q.db.ExecContext(ctx, upsertSyncState,
+		arg.Username,
+		arg.BackfillCursor,
+		arg.BackfillComplete,
+		arg.UpdatedTime,
+	)
+	return err
+}
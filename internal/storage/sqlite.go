@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Settings configure every connection to the database.
+type Settings struct {
+	// JournalMode is usually WAL, which lets reads carry on while a write is in progress.
+	JournalMode string
+	// BusyTimeout is how long a connection waits on a lock held by another (e.g. a backup, or stravasync running
+	// alongside the webapp) before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	// Synchronous is NORMAL by default, which is safe with WAL: a power loss can lose the last few commits, but never
+	// corrupts the database.
+	Synchronous string
+	// ReadConns is the size of the read pool.  There is always just one writer.
+	ReadConns int
+}
+
+// DefaultSettings are the settings to use unless there's a reason not to.
+func DefaultSettings() Settings {
+	readConns := runtime.NumCPU()
+	if readConns < 4 {
+		readConns = 4
+	}
+
+	return Settings{
+		JournalMode: "WAL",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+		Synchronous: "NORMAL",
+		ReadConns:   readConns,
+	}
+}
+
+// DB is a sqlite database with separate connection pools for reading and writing.  sqlite only allows one writer at a
+// time, so all writes go through a single connection, and queue up for it here rather than failing with SQLITE_BUSY.
+// Reads use a pool of query-only connections, which (in WAL mode) aren't blocked by the writer.
+//
+// DB implements DBTX, sending Exec (and transactions) to the writer and queries to the readers, so it can be used with
+// New like a *sql.DB.
+type DB struct {
+	Read  *sql.DB
+	Write *sql.DB
+
+	settings Settings
+}
+
+// Open opens (creating if necessary) the sqlite database file with the given settings.
+func Open(file string, settings Settings) (*DB, error) {
+	params := make(url.Values)
+	params.Set("_journal_mode", settings.JournalMode)
+	params.Set("_busy_timeout", strconv.FormatInt(settings.BusyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(settings.ForeignKeys))
+	params.Set("_synchronous", settings.Synchronous)
+
+	// writes take the lock when a transaction begins, rather than failing part way through if a reader in another
+	// process holds it
+	writeParams := cloneValues(params)
+	writeParams.Set("_txlock", "immediate")
+	write, err := sql.Open("sqlite3", "file:"+file+"?"+writeParams.Encode())
+	if err != nil {
+		return nil, err
+	}
+	write.SetMaxOpenConns(1)
+
+	// open the writer first, so that the file exists (and is in WAL mode) before any reader opens it
+	if err := write.Ping(); err != nil {
+		write.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+
+	readParams := cloneValues(params)
+	readParams.Set("_query_only", "true")
+	read, err := sql.Open("sqlite3", "file:"+file+"?"+readParams.Encode())
+	if err != nil {
+		write.Close()
+		return nil, err
+	}
+	read.SetMaxOpenConns(settings.ReadConns)
+	read.SetMaxIdleConns(settings.ReadConns)
+
+	return &DB{Read: read, Write: write, settings: settings}, nil
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+func (db *DB) Close() error {
+	readErr := db.Read.Close()
+	if err := db.Write.Close(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.Write.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.Write.PrepareContext(ctx, query)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.Read.QueryContext(ctx, query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.Read.QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx begins a transaction on the writer.  Don't write outside of the transaction until it's done, since the
+// writer is busy until then.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.Write.BeginTx(ctx, opts)
+}
+
+// Describe returns the settings in effect on each pool, as reported by sqlite itself, for logging.
+func (db *DB) Describe(ctx context.Context) ([]string, error) {
+	var version string
+	if err := db.Read.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
+		return nil, fmt.Errorf("failed to read sqlite version: %w", err)
+	}
+	lines := []string{"sqlite " + version}
+
+	pools := []struct {
+		name  string
+		db    *sql.DB
+		conns int
+	}{
+		{"write", db.Write, 1},
+		{"read", db.Read, db.settings.ReadConns},
+	}
+	for _, pool := range pools {
+		settings := []string{fmt.Sprintf("max_conns=%d", pool.conns)}
+		for _, pragma := range []string{"journal_mode", "busy_timeout", "foreign_keys", "synchronous", "query_only"} {
+			var value string
+			if err := pool.db.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&value); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", pragma, err)
+			}
+			settings = append(settings, pragma+"="+value)
+		}
+		lines = append(lines, pool.name+": "+strings.Join(settings, " "))
+	}
+	return lines, nil
+}
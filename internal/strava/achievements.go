@@ -0,0 +1,294 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	AchievementMiles   = "miles"
+	AchievementGoal    = "goal"
+	AchievementLongest = "longest"
+	AchievementStreak  = "streak"
+
+	// achievements are only announced if they were earned this recently, so that backfilling old history records
+	// past milestones without sending a flood of notifications about them
+	notifyWindow = 7 * 24 * time.Hour
+
+	// streaks only count as records once they beat a previous best of at least this many days
+	minStreakRecord = 3
+)
+
+// yearly mileage milestones
+var mileageMilestones = []float64{100, 500, 1000}
+
+// Achievement is a milestone that an athlete reached with a particular activity.
+type Achievement struct {
+	Kind        string
+	Key         string // unique per athlete, so each achievement is only ever recorded once
+	ActivityId  int64
+	Date        time.Time
+	Description string
+}
+
+// MilestoneWatcher records achievements as activities arrive, and sends notifications for newly earned ones.
+type MilestoneWatcher struct {
+	Db       *SqliteDb
+	Notifier Notifier
+}
+
+// ActivitiesArrived is an ActivityListener that re-checks the athlete's stored history for achievements, as far as it
+// is known to be complete (see completeSince).  Newly earned achievements are recorded, and any that are no longer
+// earned (say, a "longest run" that a backfill found was beaten years ago, or one set by an activity that was later
+// excluded as a duplicate) are deleted.  Only achievements earned by one of the newly added activities are announced.
+func (m *MilestoneWatcher) ActivitiesArrived(ctx context.Context, username string, added []Activity) {
+	history, err := m.Db.LoadActivities(ctx, username, time.Unix(0, 0), time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		log.Printf("error: failed to load activities for %s to check achievements: %s", username, err)
+		return
+	}
+
+	since, err := completeSince(ctx, username, m.Db, time.Now())
+	if err != nil {
+		log.Printf("error: failed to read sync state for %s: %s", username, err)
+		return
+	}
+
+	decisions, err := m.Db.ReadDuplicateDecisions(ctx, username)
+	if err != nil {
+		log.Printf("error: failed to read duplicate decisions for %s: %s", username, err)
+		return
+	}
+	history = decisions.Counted(history)
+
+	isNew := make(map[int64]bool)
+	for _, activity := range added {
+		isNew[activity.Id] = true
+	}
+
+	var announce []*Achievement
+	// use the athlete's own mileage goals where they've set them
+	goalMiles := make(map[int]float64)
+	goalFor := func(year int) float64 {
+		if miles, ok := goalMiles[year]; ok {
+			return miles
+		}
+
+		goals, err := yearGoals(ctx, username, year, m.Db)
+		if err != nil {
+			log.Printf("error: failed to read %d goals for %s: %s", year, username, err)
+		}
+		for _, goal := range goals {
+			if goal.Kind == GoalDistance {
+				goalMiles[year] = goal.Target
+			}
+		}
+		return goalMiles[year] // zero if the athlete has goals, just not for distance
+	}
+
+	earned := make(map[string]bool)
+	for _, achievement := range detectAchievements(history, goalFor, since) {
+		earned[achievement.Key] = true
+		n, err := m.Db.query.InsertAchievement(ctx, storage.InsertAchievementParams{
+			Username:     username,
+			Key:          achievement.Key,
+			Kind:         achievement.Kind,
+			ActivityID:   achievement.ActivityId,
+			AchievedDate: achievement.Date.UTC(),
+			Description:  achievement.Description,
+			CreatedTime:  time.Now(),
+		})
+		if err != nil {
+			log.Printf("error: failed to record achievement %q for %s: %s", achievement.Key, username, err)
+			continue
+		}
+
+		if n > 0 && isNew[achievement.ActivityId] && time.Since(achievement.Date) < notifyWindow {
+			announce = append(announce, achievement)
+		}
+	}
+
+	stored, err := m.Db.ListAchievements(ctx, username)
+	if err != nil {
+		log.Printf("error: failed to read achievements for %s: %s", username, err)
+		return
+	}
+	for _, achievement := range stored {
+		if earned[achievement.Key] || !isJudged(achievement.Kind, achievement.AchievedDate, since) {
+			continue
+		}
+		arg := storage.DeleteAchievementParams{Username: username, Key: achievement.Key}
+		if err := m.Db.query.DeleteAchievement(ctx, arg); err != nil {
+			log.Printf("error: failed to delete achievement %q for %s: %s", achievement.Key, username, err)
+		}
+	}
+
+	if len(announce) == 0 || m.Notifier == nil {
+		return
+	}
+
+	// don't hold up whoever saved the activities (often a page load) while notifications go out
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, achievement := range announce {
+			if err := m.Notifier.Notify(ctx, username, achievement); err != nil {
+				log.Printf("error: failed to send notification of %q for %s: %s", achievement.Key, username, err)
+			}
+		}
+	}()
+}
+
+func (db *SqliteDb) ListAchievements(ctx context.Context, username string) ([]storage.StravaAchievement, error) {
+	return db.query.ListAchievements(ctx, username)
+}
+
+// completeSince returns the time from which the athlete's stored history is known to be complete.  That's all of it
+// once stravasync has backfilled it or the athlete has imported their archive, and otherwise just the current year,
+// which the running page syncs in full.
+func completeSince(ctx context.Context, username string, db *SqliteDb, now time.Time) (time.Time, error) {
+	state, err := db.query.FetchSyncState(ctx, username)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	if state.BackfillComplete {
+		return time.Time{}, nil
+	}
+	return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// isJudged returns whether an achievement of the given kind and date can be judged from history that is complete from
+// since.  Yearly milestones need the whole year, and records need everything before them.
+func isJudged(kind string, date, since time.Time) bool {
+	switch kind {
+	case AchievementMiles, AchievementGoal:
+		return !time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC).Before(since)
+	default:
+		return since.IsZero()
+	}
+}
+
+// detectAchievements finds every achievement earned over the given history, which must be in chronological order and
+// complete from since.  Achievements that can't be judged from the history (see isJudged) are left out.
+func detectAchievements(history []Activity, goalFor func(year int) float64, since time.Time) []*Achievement {
+	var achievements []*Achievement
+
+	year := 0
+	var yearMiles, longest float64
+	var streak, bestStreak int
+	var lastDay, streakStart time.Time
+	streakRecorded := false
+
+	for i := range history {
+		activity := &history[i]
+		if activity.Type != "Run" {
+			continue
+		}
+
+		start := activity.StartTime()
+		if start.Year() != year {
+			year = start.Year()
+			yearMiles = 0
+		}
+
+		before := yearMiles
+		yearMiles += activity.Miles()
+		for _, milestone := range mileageMilestones {
+			if before < milestone && yearMiles >= milestone {
+				achievements = append(achievements, &Achievement{
+					Kind:        AchievementMiles,
+					Key:         fmt.Sprintf("miles:%d:%.0f", year, milestone),
+					ActivityId:  activity.Id,
+					Date:        start,
+					Description: fmt.Sprintf("First %.0f miles of %d", milestone, year),
+				})
+			}
+		}
+
+		if goal := goalFor(year); goal > 0 && before < goal && yearMiles >= goal {
+			achievements = append(achievements, &Achievement{
+				Kind:        AchievementGoal,
+				Key:         fmt.Sprintf("goal:%d", year),
+				ActivityId:  activity.Id,
+				Date:        start,
+				Description: fmt.Sprintf("Hit the %d goal of %.0f miles", year, goal),
+			})
+		}
+
+		if longest > 0 && activity.DistanceMeters > longest {
+			achievements = append(achievements, &Achievement{
+				Kind:        AchievementLongest,
+				Key:         fmt.Sprintf("longest:%d", activity.Id),
+				ActivityId:  activity.Id,
+				Date:        start,
+				Description: fmt.Sprintf("New longest run: %.1f miles", activity.Miles()),
+			})
+		}
+		if activity.DistanceMeters > longest {
+			longest = activity.DistanceMeters
+		}
+
+		day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		switch {
+		case day.Equal(lastDay):
+			continue // second run of the day doesn't extend the streak
+		case day.Equal(lastDay.AddDate(0, 0, 1)):
+			streak++
+		default:
+			if streak > bestStreak {
+				bestStreak = streak
+			}
+			streak = 1
+			streakStart = day
+			streakRecorded = false
+		}
+		lastDay = day
+
+		// only record a streak once, when it first beats the previous best
+		if !streakRecorded && bestStreak >= minStreakRecord && streak > bestStreak {
+			streakRecorded = true
+			achievements = append(achievements, &Achievement{
+				Kind:        AchievementStreak,
+				Key:         fmt.Sprintf("streak:%s", streakStart.Format("2006-01-02")),
+				ActivityId:  activity.Id,
+				Date:        start,
+				Description: fmt.Sprintf("New run streak record: %d days and counting (previous best %d)", streak, bestStreak),
+			})
+		}
+	}
+
+	judged := achievements[:0]
+	for _, achievement := range achievements {
+		if isJudged(achievement.Kind, achievement.Date, since) {
+			judged = append(judged, achievement)
+		}
+	}
+	return judged
+}
+
+// achievementView is an entry in the badge timeline on the running page.
+type achievementView struct {
+	Kind        string
+	Date        string
+	Description string
+	Url         string
+}
+
+func newAchievementViews(achievements []storage.StravaAchievement) []*achievementView {
+	var views []*achievementView
+	for _, achievement := range achievements {
+		views = append(views, &achievementView{
+			Kind:        achievement.Kind,
+			Date:        achievement.AchievedDate.Format("Jan 2, 2006"),
+			Description: achievement.Description,
+			Url:         fmt.Sprintf("/running/activity/%d", achievement.ActivityID),
+		})
+	}
+	return views
+}
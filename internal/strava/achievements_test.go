@@ -0,0 +1,241 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// newTestDb returns an empty, fully migrated database that is deleted when the test ends.
+func newTestDb(t *testing.T) *SqliteDb {
+	t.Helper()
+
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.sqlite"), storage.DefaultSettings())
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := storage.MigrateDatabase(context.Background(), db.Write, false); err != nil {
+		t.Fatalf("failed to migrate database: %s", err)
+	}
+	return NewSqliteDb(db)
+}
+
+func testRun(id int64, date string, meters float64) Activity {
+	return Activity{Id: id, Name: fmt.Sprintf("run %d", id), Type: "Run", DistanceMeters: meters, MovingTime: meters / 3,
+		StartDate: date + "T12:00:00Z"}
+}
+
+func TestDetectAchievements(t *testing.T) {
+	// 16km runs every other day, the 11th of which passes 100 miles
+	var everyOtherDay []Activity
+	for i := 0; i < 12; i++ {
+		date := time.Date(2024, time.March, 1+2*i, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+		everyOtherDay = append(everyOtherDay, testRun(int64(i+1), date, 16000))
+	}
+
+	noGoals := func(int) float64 { return 0 }
+	complete := time.Time{}
+
+	tests := []struct {
+		name    string
+		history []Activity
+		goalFor func(year int) float64
+		since   time.Time
+		want    []string // key@activity
+	}{
+		{
+			name:    "mileage milestone",
+			history: everyOtherDay,
+			goalFor: noGoals,
+			since:   complete,
+			want:    []string{"miles:2024:100@11"},
+		},
+		{
+			name:    "mileage goal",
+			history: everyOtherDay,
+			goalFor: func(year int) float64 { return 50 },
+			since:   complete,
+			want:    []string{"goal:2024@6", "miles:2024:100@11"},
+		},
+		{
+			name: "longest run",
+			history: []Activity{
+				testRun(1, "2024-01-01", 10000),
+				testRun(2, "2024-01-05", 15000),
+				testRun(3, "2024-01-09", 12000),
+				testRun(4, "2024-01-13", 21100),
+				testRun(5, "2024-01-17", 21100),
+			},
+			goalFor: noGoals,
+			since:   complete,
+			want:    []string{"longest:2@2", "longest:4@4"},
+		},
+		{
+			name: "streak",
+			history: []Activity{
+				testRun(1, "2024-01-01", 5000),
+				testRun(2, "2024-01-02", 5000),
+				testRun(3, "2024-01-03", 5000),
+				testRun(4, "2024-01-06", 5000),
+				testRun(5, "2024-01-07", 5000),
+				testRun(6, "2024-01-07", 5000), // a second run in a day doesn't extend the streak
+				testRun(7, "2024-01-08", 5000),
+				testRun(8, "2024-01-09", 5000),
+				testRun(9, "2024-01-10", 5000),
+			},
+			goalFor: noGoals,
+			since:   complete,
+			want:    []string{"streak:2024-01-06@8"},
+		},
+		{
+			name: "only runs count",
+			history: []Activity{
+				testRun(1, "2024-01-01", 5000),
+				{Id: 2, Type: "Ride", DistanceMeters: 200000, StartDate: "2024-01-02T12:00:00Z"},
+				testRun(3, "2024-01-03", 6000),
+			},
+			goalFor: noGoals,
+			since:   complete,
+			want:    []string{"longest:3@3"},
+		},
+		{
+			name: "partial history",
+			history: append(everyOtherDay, []Activity{
+				testRun(20, "2025-02-01", 170000),
+				testRun(21, "2025-02-03", 30000),
+			}...),
+			goalFor: noGoals,
+			since:   time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			want:    []string{"miles:2025:100@20"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, achievement := range detectAchievements(tt.history, tt.goalFor, tt.since) {
+				got = append(got, fmt.Sprintf("%s@%d", achievement.Key, achievement.ActivityId))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detectAchievements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsJudged(t *testing.T) {
+	since := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		kind  string
+		date  time.Time
+		since time.Time
+		want  bool
+	}{
+		{AchievementMiles, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), time.Time{}, true},
+		{AchievementMiles, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), since, false},
+		{AchievementGoal, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), since, true},
+		{AchievementLongest, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), since, false},
+		{AchievementStreak, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		if got := isJudged(tt.kind, tt.date, tt.since); got != tt.want {
+			t.Errorf("isJudged(%s, %s, %s) = %t, want %t", tt.kind, tt.date.Format("2006-01-02"),
+				tt.since.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+// recordingNotifier is a Notifier that passes on the keys of the achievements it's asked to announce.
+type recordingNotifier chan string
+
+func (n recordingNotifier) Notify(_ context.Context, _ string, achievement *Achievement) error {
+	n <- achievement.Key
+	return nil
+}
+
+func TestMilestoneWatcher(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDb(t)
+	notified := make(recordingNotifier, 10)
+	watcher := &MilestoneWatcher{Db: db, Notifier: notified}
+	db.AddListener(watcher.ActivitiesArrived)
+
+	now := time.Now().UTC()
+	daysAgo := func(id int64, days int, meters float64) json.RawMessage {
+		run := testRun(id, now.AddDate(0, 0, -days).Format("2006-01-02"), meters)
+		b, err := json.Marshal(&run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	keys := func() []string {
+		achievements, err := db.ListAchievements(ctx, "athlete")
+		if err != nil {
+			t.Fatalf("failed to list achievements: %s", err)
+		}
+		var keys []string
+		for _, achievement := range achievements {
+			if achievement.Kind == AchievementLongest {
+				keys = append(keys, achievement.Key)
+			}
+		}
+		return keys
+	}
+
+	if _, err := db.SaveActivities(ctx, "athlete", []json.RawMessage{daysAgo(1, 20, 10000), daysAgo(2, 15, 12000)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(); len(got) != 0 {
+		t.Errorf("records %q were judged before history was backfilled", got)
+	}
+
+	// once the backfill completes, records are judged over the whole history, but old ones aren't announced
+	err := db.query.UpsertSyncState(ctx, storage.UpsertSyncStateParams{Username: "athlete", BackfillCursor: now,
+		BackfillComplete: true, UpdatedTime: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.HistoryChanged(ctx, "athlete")
+	if got, want := keys(), []string{"longest:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after backfill, records are %q, want %q", got, want)
+	}
+
+	if _, err := db.SaveActivities(ctx, "athlete", []json.RawMessage{daysAgo(3, 1, 21100)}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case key := <-notified:
+		if key != "longest:3" {
+			t.Errorf("announced %q, want longest:3", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new record was never announced")
+	}
+
+	// excluding the record-setting run as a duplicate takes its record away again
+	err = db.query.UpsertDuplicateDecision(ctx, storage.UpsertDuplicateDecisionParams{Username: "athlete",
+		ActivityID: 3, OtherID: 2, Excluded: true, UpdatedTime: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.HistoryChanged(ctx, "athlete")
+	if got, want := keys(), []string{"longest:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after excluding a duplicate, records are %q, want %q", got, want)
+	}
+
+	select {
+	case key := <-notified:
+		t.Errorf("unexpectedly announced %q", key)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
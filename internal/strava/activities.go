@@ -0,0 +1,120 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// ActivityListener is called with activities that have just been added to the local activity store, or with none when
+// the athlete's stored history has changed in some other way (see HistoryChanged).
+type ActivityListener func(ctx context.Context, username string, added []Activity)
+
+// AddListener registers a function to be called whenever SaveActivities adds new activities, or HistoryChanged is
+// called.
+func (db *SqliteDb) AddListener(l ActivityListener) {
+	db.listeners = append(db.listeners, l)
+}
+
+// HistoryChanged tells listeners that the athlete's history has changed other than by new activities arriving, e.g.
+// because activities were deleted or excluded as duplicates, or a backfill completed.
+func (db *SqliteDb) HistoryChanged(ctx context.Context, username string) {
+	for _, l := range db.listeners {
+		l(ctx, username, nil)
+	}
+}
+
+// SaveActivities upserts activities, given as the raw JSON returned by the Strava API, into the local activity store.
+// All activities are written in a single transaction, after which listeners are notified of any that weren't
+// previously stored.
+func (db *SqliteDb) SaveActivities(ctx context.Context, username string, raws []json.RawMessage) ([]Activity, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := db.query.WithTx(tx)
+	now := time.Now()
+
+	activities := make([]Activity, len(raws))
+	var added []Activity
+	for i, raw := range raws {
+		if err := json.Unmarshal(raw, &activities[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse activity: %w", err)
+		}
+
+		count, err := query.CountActivity(ctx, storage.CountActivityParams{ID: activities[i].Id, Username: username})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read activity %d: %w", activities[i].Id, err)
+		}
+		if count == 0 {
+			added = append(added, activities[i])
+		}
+
+		arg := storage.UpsertActivityParams{
+			ID:          activities[i].Id,
+			Username:    username,
+			StartDate:   activities[i].StartTime().UTC(),
+			Data:        string(raw),
+			UpdatedTime: now,
+		}
+		if err := query.UpsertActivity(ctx, arg); err != nil {
+			return nil, fmt.Errorf("failed to write activity %d: %w", arg.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if len(added) > 0 {
+		for _, l := range db.listeners {
+			l(ctx, username, added)
+		}
+	}
+	return activities, nil
+}
+
+// syncActivities fetches the athlete's activities in [start, finish) from Strava and saves them to the local activity
+// store.
+func syncActivities(ctx context.Context, username string, start, finish time.Time, db *SqliteDb, account *ApiParams) ([]Activity, error) {
+	accessToken, err := readAccessToken(ctx, username, db, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access token: %w", err)
+	}
+
+	raws, err := getActivitiesRaw(account, accessToken, start, finish)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.SaveActivities(ctx, username, raws)
+}
+
+// LoadActivities returns all locally stored activities that started in [start, finish), ordered by start date.
+func (db *SqliteDb) LoadActivities(ctx context.Context, username string, start, finish time.Time) ([]Activity, error) {
+	rows, err := db.query.ListActivities(ctx, storage.ListActivitiesParams{
+		Username:    username,
+		StartDate:   start.UTC(),
+		StartDate_2: finish.UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]Activity, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal([]byte(row.Data), &activities[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse stored activity %d: %w", row.ID, err)
+		}
+	}
+	return activities, nil
+}
+
+func (db *SqliteDb) DeleteActivity(ctx context.Context, username string, id int64) error {
+	return db.query.DeleteActivity(ctx, storage.DeleteActivityParams{ID: id, Username: username})
+}
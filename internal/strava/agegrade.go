@@ -0,0 +1,152 @@
+package strava
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AgeFactors are age-grading tables in the World Masters Athletics (WMA) format, which compare a time with the best
+// possible for the athlete's age and sex: the age grade is the open-class standard divided by the time multiplied by
+// the athlete's age factor.
+//
+// WMA publishes (and every few years revises) the tables as spreadsheets, so they aren't bundled here.  Instead,
+// LoadAgeFactors reads them from a CSV exported from the road running spreadsheets, laid out like:
+//
+//	sex,age,5k,10k,half,marathon
+//	M,standard,<open class standard for each distance, in seconds>
+//	M,35,<age factor for each distance>
+//	...
+//
+// Distance columns are named like registered races (see standardDistances).  Each sex's "standard" row holds the open
+// class standards, in seconds, and its other rows hold the factors for one age each.
+type AgeFactors struct {
+	distances []float64 // meters, ascending
+	standards map[string][]float64
+	factors   map[string]map[int][]float64
+}
+
+// LoadAgeFactors reads age-grading tables from a CSV file; see AgeFactors for the format.
+func LoadAgeFactors(path string) (*AgeFactors, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	factors, err := parseAgeFactors(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return factors, nil
+}
+
+func parseAgeFactors(r io.Reader) (*AgeFactors, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 || len(rows[0]) < 3 || rows[0][0] != "sex" || rows[0][1] != "age" {
+		return nil, fmt.Errorf("expected a header row of sex,age followed by distances")
+	}
+
+	// columns are sorted by distance, so that interpolation can find its neighbors
+	header := rows[0][2:]
+	order := make([]int, len(header))
+	meters := make([]float64, len(header))
+	for i, name := range header {
+		d, ok := standardDistances[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown distance %q", name)
+		}
+		order[i], meters[i] = i, d
+	}
+	sort.Slice(order, func(i, j int) bool { return meters[order[i]] < meters[order[j]] })
+
+	t := &AgeFactors{
+		standards: make(map[string][]float64),
+		factors:   make(map[string]map[int][]float64),
+	}
+	for _, i := range order {
+		t.distances = append(t.distances, meters[i])
+	}
+
+	for n, row := range rows[1:] {
+		line := n + 2
+		sex := strings.ToUpper(strings.TrimSpace(row[0]))
+		if sex != "M" && sex != "F" {
+			return nil, fmt.Errorf("line %d: sex must be M or F, not %q", line, row[0])
+		}
+
+		values := make([]float64, len(order))
+		for i, col := range order {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[col+2]), 64)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("line %d: invalid %s value %q", line, header[col], row[col+2])
+			}
+			values[i] = v
+		}
+
+		if age := strings.TrimSpace(row[1]); age == "standard" {
+			t.standards[sex] = values
+		} else {
+			a, err := strconv.Atoi(age)
+			if err != nil || a <= 0 {
+				return nil, fmt.Errorf("line %d: invalid age %q", line, row[1])
+			}
+			if t.factors[sex] == nil {
+				t.factors[sex] = make(map[int][]float64)
+			}
+			t.factors[sex][a] = values
+		}
+	}
+
+	for sex := range t.factors {
+		if t.standards[sex] == nil {
+			return nil, fmt.Errorf("no standard row for sex %s", sex)
+		}
+	}
+	return t, nil
+}
+
+// Grade returns the age grade, as a percentage, for covering the given distance in the given time, or false if the
+// tables don't cover the athlete's sex, age or distance.  Between the tabulated distances, the standards and factors
+// are interpolated linearly.
+func (t *AgeFactors) Grade(sex string, age int, meters, seconds float64) (float64, bool) {
+	if t == nil || seconds <= 0 {
+		return 0, false
+	}
+	standards, factors := t.standards[sex], t.factors[sex][age]
+	if standards == nil || factors == nil {
+		return 0, false
+	}
+
+	standard, ok := interpolate(t.distances, standards, meters)
+	if !ok {
+		return 0, false
+	}
+	factor, _ := interpolate(t.distances, factors, meters)
+	return 100 * standard / (factor * seconds), true
+}
+
+// interpolate returns the value of ys at x, interpolating linearly between the points of xs (which are ascending).  A
+// GPS distance is rarely exact, so x may be up to 2% outside of the range of xs.
+func interpolate(xs, ys []float64, x float64) (float64, bool) {
+	first, last := xs[0], xs[len(xs)-1]
+	switch {
+	case x < 0.98*first || x > 1.02*last:
+		return 0, false
+	case x <= first:
+		return ys[0], true
+	case x >= last:
+		return ys[len(ys)-1], true
+	}
+
+	i := sort.SearchFloat64s(xs, x) // xs[i-1] < x <= xs[i]
+	f := (x - xs[i-1]) / (xs[i] - xs[i-1])
+	return ys[i-1] + f*(ys[i]-ys[i-1]), true
+}
@@ -0,0 +1,83 @@
+package strava
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// testAgeFactors are made-up tables in the WMA layout, with the distance columns out of order.
+const testAgeFactors = `sex,age,10k,5k
+M,standard,1600,780
+M,50,0.85,0.86
+F,standard,1800,870
+F,50,0.84,0.85
+`
+
+func TestAgeFactorsGrade(t *testing.T) {
+	factors, err := parseAgeFactors(strings.NewReader(testAgeFactors))
+	if err != nil {
+		t.Fatalf("failed to parse tables: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		sex     string
+		age     int
+		meters  float64
+		seconds float64
+		want    float64 // zero if it can't be graded
+	}{
+		{"5k", "M", 50, 5000, 1200, 75.581},
+		{"10k", "M", 50, 10000, 2400, 78.431},
+		{"women's 10k", "F", 50, 10000, 2700, 79.365},
+		{"interpolated", "M", 50, 7500, 1800, 77.323},
+		{"slightly short", "M", 50, 4950, 1180, 76.862},
+		{"too short", "M", 50, 4800, 1100, 0},
+		{"too long", "M", 50, 10300, 2500, 0},
+		{"age not in tables", "M", 51, 5000, 1200, 0},
+		{"sex not in tables", "X", 50, 5000, 1200, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := factors.Grade(tt.sex, tt.age, tt.meters, tt.seconds)
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("Grade() = %.3f, want no grade", got)
+				}
+				return
+			}
+			if !ok || math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("Grade() = %.3f, %t, want %.3f", got, ok, tt.want)
+			}
+		})
+	}
+
+	var none *AgeFactors
+	if _, ok := none.Grade("M", 50, 5000, 1200); ok {
+		t.Errorf("nil tables graded a time")
+	}
+}
+
+func TestParseAgeFactorsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"empty", ""},
+		{"bad header", "age,sex,5k\nM,standard,780\n"},
+		{"unknown distance", "sex,age,7k\nM,standard,780\n"},
+		{"bad sex", "sex,age,5k\nX,standard,780\n"},
+		{"bad age", "sex,age,5k\nM,standard,780\nM,old,0.9\n"},
+		{"bad factor", "sex,age,5k\nM,standard,780\nM,50,-1\n"},
+		{"missing column", "sex,age,5k,10k\nM,standard,780\n"},
+		{"no standard", "sex,age,5k\nM,50,0.9\n"},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseAgeFactors(strings.NewReader(tt.csv)); err == nil {
+			t.Errorf("%s: parsed without an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package strava
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	// largest archive that may be uploaded through the webapp; bigger ones can be imported with stravasync
+	maxArchiveBytes = 2 << 30
+
+	// how long an upload may take to arrive and be imported, in place of the server's read and write timeouts
+	archiveUploadTimeout = 30 * time.Minute
+
+	// mean radius of the earth, for measuring GPX tracks
+	earthRadiusMeters = 6371008.8
+)
+
+// archiveDateLayouts are the formats that activity dates have been seen in, in Strava's activities.csv.  Dates are UTC.
+var archiveDateLayouts = []string{"Jan 2, 2006, 3:04:05 PM", "2 Jan 2006, 15:04:05", "2006-01-02 15:04:05"}
+
+// ArchiveReport summarizes an archive import.
+type ArchiveReport struct {
+	Imported int // newly added to the local activity store
+	Existing int // already stored, and left alone
+	FromGpx  int // imported activities that were missing summary values, which were measured from their GPX files
+	Invalid  []string
+}
+
+func (r *ArchiveReport) String() string {
+	s := fmt.Sprintf("imported %d activities (%d already stored, %d measured from GPX files, %d invalid)",
+		r.Imported, r.Existing, r.FromGpx, len(r.Invalid))
+	for _, msg := range r.Invalid {
+		s += "\n  " + msg
+	}
+	return s
+}
+
+// ImportArchive adds the activities in a Strava "download your data" export zip to the athlete's local activity store,
+// so that their history is available without fetching it all from the API.  Activity summaries come from the archive's
+// activities.csv; where a summary is missing its distance or time, they are measured from the activity's GPX file
+// instead (FIT files aren't decoded), and if that isn't possible, a distance in the athlete's preferred units makes the
+// activity invalid rather than being guessed at.  Activities that are already stored are left alone, since the API's
+// copy has more detail.
+//
+// The export doesn't say who may see each activity, so imported activities are marked private, which keeps them off
+// public share pages until they are replaced by a sync from the API.
+//
+// An export holds the athlete's whole history, so unless some of its activities were invalid, their history is marked
+// as complete through the newest of them, just as a finished stravasync backfill would.  Records are judged from then
+// on (see completeSince).
+func ImportArchive(ctx context.Context, db *SqliteDb, username string, r io.ReaderAt, size int64) (*ArchiveReport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	var index *zip.File
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "activities.csv" && (index == nil || len(f.Name) < len(index.Name)) {
+			index = f
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("no activities.csv in archive")
+	}
+
+	rc, err := index.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", index.Name, err)
+	}
+	defer rc.Close()
+
+	rows, err := readArchiveIndex(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", index.Name, err)
+	}
+
+	report := &ArchiveReport{}
+	var raws []json.RawMessage
+	var newest time.Time
+	for _, row := range rows {
+		activity, err := row.activity()
+		if err != nil {
+			report.Invalid = append(report.Invalid, err.Error())
+			continue
+		}
+
+		count, err := db.query.CountActivity(ctx, storage.CountActivityParams{ID: activity.Id, Username: username})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read activity %d: %w", activity.Id, err)
+		}
+		if count > 0 {
+			if t := activity.StartTime(); activity.StartDate != "" && t.After(newest) {
+				newest = t
+			}
+			report.Existing++
+			continue
+		}
+
+		if activity.DistanceMeters == 0 || activity.MovingTime == 0 || activity.StartDate == "" {
+			// the file's path is relative to the directory that activities.csv is in
+			if f := files[path.Join(path.Dir(index.Name), row.get("Filename"))]; f != nil && isGpxFile(f.Name) {
+				if err := measureGpx(f, activity); err != nil {
+					report.Invalid = append(report.Invalid, fmt.Sprintf("activity %d: %s", activity.Id, err))
+					continue
+				}
+				report.FromGpx++
+			}
+		}
+		if activity.StartDate == "" {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("activity %d: no start date", activity.Id))
+			continue
+		}
+		// if only the athlete's preferred units are given, it isn't known whether they're km or miles
+		preferred, _ := strconv.ParseFloat(strings.TrimSpace(row.earlier["Distance"]), 64)
+		if activity.DistanceMeters == 0 && preferred != 0 {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("activity %d: no distance in meters", activity.Id))
+			continue
+		}
+		if t := activity.StartTime(); t.After(newest) {
+			newest = t
+		}
+
+		raw, err := json.Marshal(activity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode activity %d: %w", activity.Id, err)
+		}
+		raws = append(raws, raw)
+	}
+
+	if _, err := db.SaveActivities(ctx, username, raws); err != nil {
+		return nil, err
+	}
+	report.Imported = len(raws)
+
+	if len(report.Invalid) == 0 && !newest.IsZero() {
+		if err := markHistoryComplete(ctx, db, username, newest); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// markHistoryComplete records that the athlete's stored history is complete through the given time, which is where a
+// later stravasync backfill picks up from.
+func markHistoryComplete(ctx context.Context, db *SqliteDb, username string, through time.Time) error {
+	state, err := db.query.FetchSyncState(ctx, username)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read sync state: %w", err)
+	}
+	if state.BackfillComplete {
+		return nil
+	}
+
+	// never move the cursor backwards, past activities that an earlier backfill has already fetched
+	cursor := through.UTC()
+	if state.BackfillCursor.After(cursor) {
+		cursor = state.BackfillCursor
+	}
+	err = db.query.UpsertSyncState(ctx, storage.UpsertSyncStateParams{
+		Username:         username,
+		BackfillCursor:   cursor,
+		BackfillComplete: true,
+		UpdatedTime:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	db.HistoryChanged(ctx, username)
+	return nil
+}
+
+// archiveRow is one row of activities.csv.
+type archiveRow struct {
+	values  map[string]string
+	earlier map[string]string // columns that appear again later in the row, e.g. in the athlete's preferred units
+}
+
+// readArchiveIndex reads activities.csv.  Some columns appear twice, e.g. "Distance" is first in the athlete's
+// preferred units and later in meters; the later, unconverted, values are the ones kept.  The earlier values are only
+// kept apart, never used in their place, since their units differ.
+func readArchiveIndex(r io.Reader) ([]*archiveRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]int)
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		last[header[i]] = i
+	}
+
+	var rows []*archiveRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := &archiveRow{values: make(map[string]string), earlier: make(map[string]string)}
+		for i, name := range header {
+			if i >= len(record) || record[i] == "" {
+				continue
+			}
+			if i == last[name] {
+				row.values[name] = record[i]
+			} else {
+				row.earlier[name] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (row *archiveRow) get(name string) string {
+	return strings.TrimSpace(row.values[name])
+}
+
+func (row *archiveRow) float(name string) float64 {
+	f, err := strconv.ParseFloat(row.get(name), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// activity converts the row to an Activity, as the Strava API would have returned it.
+func (row *archiveRow) activity() (*Activity, error) {
+	id, err := strconv.ParseInt(row.get("Activity ID"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activity id %q", row.get("Activity ID"))
+	}
+
+	activity := &Activity{
+		Id:                 id,
+		Name:               row.get("Activity Name"),
+		DistanceMeters:     row.float("Distance"),
+		MovingTime:         row.float("Moving Time"),
+		ElapsedTime:        row.float("Elapsed Time"),
+		TotalElevationGain: row.float("Elevation Gain"),
+		Type:               strings.ReplaceAll(row.get("Activity Type"), " ", ""),
+		AverageHeartrate:   row.float("Average Heart Rate"),
+		MaxHeartrate:       row.float("Max Heart Rate"),
+		AverageCadence:     row.float("Average Cadence"),
+		SufferScore:        row.float("Relative Effort"),
+		Private:            true,
+	}
+	if activity.MovingTime == 0 {
+		activity.MovingTime = activity.ElapsedTime
+	}
+
+	if s := row.get("Activity Date"); s != "" {
+		for _, layout := range archiveDateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				activity.StartDate = t.UTC().Format(time.RFC3339)
+				break
+			}
+		}
+		if activity.StartDate == "" {
+			return nil, fmt.Errorf("activity %d: invalid date %q", id, s)
+		}
+	}
+	return activity, nil
+}
+
+func isGpxFile(name string) bool {
+	return strings.HasSuffix(name, ".gpx") || strings.HasSuffix(name, ".gpx.gz")
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  float64   `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+type gpxFile struct {
+	Segments []struct {
+		Points []gpxPoint `xml:"trkpt"`
+	} `xml:"trk>trkseg"`
+}
+
+// measureGpx fills in whatever the activity is missing of its start date, distance, times and elevation gain, by
+// measuring its GPX track.
+func measureGpx(f *zip.File, activity *Activity) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if strings.HasSuffix(f.Name, ".gz") {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", f.Name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var gpx gpxFile
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", f.Name, err)
+	}
+
+	var points []gpxPoint
+	for _, seg := range gpx.Segments {
+		points = append(points, seg.Points...)
+	}
+	if len(points) < 2 {
+		return fmt.Errorf("%s has no track", f.Name)
+	}
+
+	var meters, gain float64
+	for i := 1; i < len(points); i++ {
+		meters += haversineMeters(points[i-1], points[i])
+		if climb := points[i].Ele - points[i-1].Ele; climb > 0 {
+			gain += climb
+		}
+	}
+	first, last := points[0], points[len(points)-1]
+
+	if activity.StartDate == "" && !first.Time.IsZero() {
+		activity.StartDate = first.Time.UTC().Format(time.RFC3339)
+	}
+	if activity.DistanceMeters == 0 {
+		activity.DistanceMeters = meters
+	}
+	if activity.ElapsedTime == 0 && !first.Time.IsZero() {
+		activity.ElapsedTime = last.Time.Sub(first.Time).Seconds()
+	}
+	if activity.MovingTime == 0 {
+		activity.MovingTime = activity.ElapsedTime // GPX doesn't say when the athlete was stopped
+	}
+	if activity.TotalElevationGain == 0 {
+		activity.TotalElevationGain = gain
+	}
+	return nil
+}
+
+func haversineMeters(a, b gpxPoint) float64 {
+	toRad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * toRad
+	dLon := (b.Lon - a.Lon) * toRad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*toRad)*math.Cos(b.Lat*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+// ImportHandler handles uploads of a Strava export zip, which is imported into the athlete's local activity store.
+func ImportHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	// the server's timeouts are sized for pages, not for an archive of years of GPX files.  These go through the
+	// ResponseController, so that under HTTP/2 they apply only to this stream; writers that can't take a deadline
+	// have no timeout to replace.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(archiveUploadTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	// zip files need random access, so spool the upload to a temp file rather than holding it in memory
+	mr, err := r.MultipartReader()
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "expected a multipart upload: %s", err)
+		return
+	}
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != "archive" {
+		internal.HttpError(w, http.StatusBadRequest, "no archive uploaded")
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "strava-archive-*.zip")
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to create temp file: %s", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.LimitReader(part, maxArchiveBytes+1))
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "failed to read upload: %s", err)
+		return
+	}
+	if size > maxArchiveBytes {
+		internal.HttpError(w, http.StatusRequestEntityTooLarge, "archive is too large to upload (max %d bytes); import it with stravasync instead", maxArchiveBytes)
+		return
+	}
+
+	report, err := ImportArchive(r.Context(), db, username, tmp, size)
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "failed to import archive: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, report)
+}
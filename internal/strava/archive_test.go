@@ -0,0 +1,341 @@
+package strava
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive returns a Strava export zip holding the given files.
+func testArchive(t *testing.T, files map[string]string) *bytes.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// testArchiveHeader is the start of activities.csv's header, with distances first in km and then in meters.
+const testArchiveHeader = "Activity ID,Activity Date,Activity Name,Activity Type,Elapsed Time,Distance," +
+	"Moving Time,Distance\n"
+
+// testTrack runs east along the equator, 0.01 degrees (about 1112 meters) at a time, climbing 13 meters in all.
+const testTrack = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test">
+ <trk>
+  <trkseg>
+   <trkpt lat="0" lon="0"><ele>10</ele><time>2024-05-05T07:30:00Z</time></trkpt>
+   <trkpt lat="0" lon="0.01"><ele>15</ele><time>2024-05-05T07:35:00Z</time></trkpt>
+  </trkseg>
+  <trkseg>
+   <trkpt lat="0" lon="0.02"><ele>12</ele><time>2024-05-05T07:40:00Z</time></trkpt>
+   <trkpt lat="0" lon="0.03"><ele>20</ele><time>2024-05-05T07:45:00Z</time></trkpt>
+  </trkseg>
+ </trk>
+</gpx>`
+
+func TestMeasureGpx(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return buf.Bytes()
+	}
+	trackMeters := 3 * 0.01 * math.Pi / 180 * earthRadiusMeters
+
+	tests := []struct {
+		name     string
+		file     string
+		contents []byte
+		activity Activity // as listed in activities.csv
+		want     Activity
+		wantErr  bool
+	}{
+		{
+			name:     "measures everything",
+			file:     "activities/1.gpx",
+			contents: []byte(testTrack),
+			want: Activity{StartDate: "2024-05-05T07:30:00Z", DistanceMeters: trackMeters, ElapsedTime: 900,
+				MovingTime: 900, TotalElevationGain: 13},
+		},
+		{
+			name:     "gzipped",
+			file:     "activities/1.gpx.gz",
+			contents: gzipped(testTrack),
+			want: Activity{StartDate: "2024-05-05T07:30:00Z", DistanceMeters: trackMeters, ElapsedTime: 900,
+				MovingTime: 900, TotalElevationGain: 13},
+		},
+		{
+			name:     "keeps what the athlete recorded",
+			file:     "activities/1.gpx",
+			contents: []byte(testTrack),
+			activity: Activity{StartDate: "2024-05-05T07:29:00Z", DistanceMeters: 3000, MovingTime: 840},
+			want: Activity{StartDate: "2024-05-05T07:29:00Z", DistanceMeters: 3000, ElapsedTime: 900,
+				MovingTime: 840, TotalElevationGain: 13},
+		},
+		{
+			name:     "no track",
+			file:     "activities/1.gpx",
+			contents: []byte(`<gpx><trk><trkseg><trkpt lat="0" lon="0"></trkpt></trkseg></trk></gpx>`),
+			wantErr:  true,
+		},
+		{
+			name:     "not gpx",
+			file:     "activities/1.gpx",
+			contents: []byte("lat,lon\n0,0\n"),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			fw, err := zw.Create(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(tt.contents)
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tt.activity
+			err = measureGpx(zr.File[0], &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("measureGpx() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("measureGpx failed: %s", err)
+			}
+			if got.StartDate != tt.want.StartDate || math.Abs(got.DistanceMeters-tt.want.DistanceMeters) > 0.01 ||
+				got.ElapsedTime != tt.want.ElapsedTime || got.MovingTime != tt.want.MovingTime ||
+				math.Abs(got.TotalElevationGain-tt.want.TotalElevationGain) > 0.01 {
+				t.Errorf("measureGpx() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportArchiveDistances(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDb(t)
+
+	// the meters column is empty for activities 2 and 3, but only 2 has a track to measure
+	archive := testArchive(t, map[string]string{
+		"activities.csv": "Activity ID,Activity Date,Activity Name,Activity Type,Elapsed Time,Distance,Filename," +
+			"Moving Time,Distance\n" +
+			"1,\"May 3, 2024, 7:00:00 AM\",Both,Run,1800,5.00,,1800,5000.0\n" +
+			"2,\"May 5, 2024, 7:30:00 AM\",Tracked,Run,900,3.34,activities/2.gpx,900,\n" +
+			"3,\"May 7, 2024, 7:00:00 AM\",Untracked,Run,1800,3.11,,1800,\n",
+		"activities/2.gpx": testTrack,
+	})
+	report, err := ImportArchive(ctx, db, "athlete", archive, archive.Size())
+	if err != nil {
+		t.Fatalf("ImportArchive failed: %s", err)
+	}
+	if report.Imported != 2 || report.FromGpx != 1 || len(report.Invalid) != 1 {
+		t.Errorf("got report %s, want 2 imported, 1 measured from GPX and 1 invalid", report)
+	}
+
+	activities, err := db.LoadActivities(ctx, "athlete", time.Time{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]float64{1: 5000, 2: 3 * 0.01 * math.Pi / 180 * earthRadiusMeters}
+	for _, activity := range activities {
+		if d, ok := want[activity.Id]; !ok || math.Abs(activity.DistanceMeters-d) > 0.01 {
+			t.Errorf("activity %d has distance %v, want %v", activity.Id, activity.DistanceMeters, d)
+		}
+		delete(want, activity.Id)
+	}
+	if len(want) != 0 {
+		t.Errorf("activities %v weren't imported", want)
+	}
+}
+
+func TestImportArchiveCompletesHistory(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDb(t)
+	db.AddListener((&MilestoneWatcher{Db: db}).ActivitiesArrived)
+
+	records := func() []string {
+		achievements, err := db.ListAchievements(ctx, "athlete")
+		if err != nil {
+			t.Fatalf("failed to list achievements: %s", err)
+		}
+		var keys []string
+		for _, achievement := range achievements {
+			if achievement.Kind == AchievementLongest {
+				keys = append(keys, achievement.Key)
+			}
+		}
+		return keys
+	}
+
+	// one invalid row means the history may be missing something, so records still aren't judged
+	archive := testArchive(t, map[string]string{"activities.csv": testArchiveHeader +
+		"1,\"Mar 2, 2020, 7:00:00 AM\",Easy,Run,1800,5.00,1800,5000.0\n" +
+		"2,\"Mar 9, 2020, 7:00:00 AM\",Long,Run,5400,16.00,5400,16000.0\n" +
+		"3,not a date,Broken,Run,1800,5.00,1800,5000.0\n"})
+	if _, err := ImportArchive(ctx, db, "athlete", archive, archive.Size()); err != nil {
+		t.Fatalf("ImportArchive failed: %s", err)
+	}
+	if got := records(); len(got) != 0 {
+		t.Errorf("records %q were judged over an incomplete archive", got)
+	}
+
+	archive = testArchive(t, map[string]string{"activities.csv": testArchiveHeader +
+		"1,\"Mar 2, 2020, 7:00:00 AM\",Easy,Run,1800,5.00,1800,5000.0\n" +
+		"2,\"Mar 9, 2020, 7:00:00 AM\",Long,Run,5400,16.00,5400,16000.0\n" +
+		"3,\"Mar 16, 2020, 7:00:00 AM\",Fixed,Run,1800,5.00,1800,5000.0\n"})
+	if _, err := ImportArchive(ctx, db, "athlete", archive, archive.Size()); err != nil {
+		t.Fatalf("ImportArchive failed: %s", err)
+	}
+	if got, want := records(), []string{"longest:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a complete import, records are %q, want %q", got, want)
+	}
+
+	state, err := db.query.FetchSyncState(ctx, "athlete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2020, time.March, 16, 7, 0, 0, 0, time.UTC)
+	if !state.BackfillComplete || !state.BackfillCursor.Equal(want) {
+		t.Errorf("sync state is %+v, want complete through %s", state, want)
+	}
+}
+
+func TestImportArchiveKeepsOtherAthletesActivities(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDb(t)
+
+	run := testRun(42, "2024-05-05", 10000)
+	b, err := json.Marshal(&run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SaveActivities(ctx, "victim", []json.RawMessage{b}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an archive that claims the victim's activity id
+	archive := testArchive(t, map[string]string{"activities.csv": testArchiveHeader +
+		"42,\"May 5, 2024, 7:00:00 AM\",Mine now,Run,60,0.10,60,100.0\n"})
+	report, err := ImportArchive(ctx, db, "uploader", archive, archive.Size())
+	if err != nil || report.Imported != 1 {
+		t.Fatalf("ImportArchive() = %v, %v, want one activity imported", report, err)
+	}
+
+	for username, want := range map[string]float64{"victim": 10000, "uploader": 100} {
+		activities, err := db.LoadActivities(ctx, username, time.Time{}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activities) != 1 || activities[0].DistanceMeters != want {
+			t.Errorf("%s has activities %+v, want one of %v meters", username, activities, want)
+		}
+	}
+}
+
+func TestImportHandlerOutlivesServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		http2 bool
+	}{
+		{"http/1.1", false},
+		{"http/2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDb(t)
+			account := &ApiParams{Hosts: []string{"example.com"}}
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ImportHandler(w, r, db, account)
+			}))
+			srv.Config.ReadTimeout = 200 * time.Millisecond
+			srv.Config.WriteTimeout = 200 * time.Millisecond
+			srv.EnableHTTP2 = tt.http2
+			srv.StartTLS()
+			defer srv.Close()
+
+			rec := httptest.NewRecorder()
+			if err := startSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), "athlete", db); err != nil {
+				t.Fatalf("failed to start session: %s", err)
+			}
+
+			archive := testArchive(t, map[string]string{"activities.csv": testArchiveHeader +
+				"1,\"Mar 2, 2020, 7:00:00 AM\",Easy,Run,1800,5.00,1800,5000.0\n"})
+
+			// the upload trickles in for well past the server's timeouts
+			pr, pw := io.Pipe()
+			mw := multipart.NewWriter(pw)
+			go func() {
+				fw, err := mw.CreateFormFile("archive", "export.zip")
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				half := archive.Size() / 2
+				io.CopyN(fw, archive, half)
+				time.Sleep(time.Second)
+				io.Copy(fw, archive)
+				pw.CloseWithError(mw.Close())
+			}()
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL, pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			for _, c := range rec.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read response: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "imported 1 ") {
+				t.Errorf("got status %d and report %q, want one activity imported", resp.StatusCode, body)
+			}
+		})
+	}
+}
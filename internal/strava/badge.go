@@ -0,0 +1,173 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ianrose14/website/internal"
+)
+
+// badgeMaxAge is how long browsers and caches may reuse a badge before checking (via its ETag) whether it changed.
+const badgeMaxAge = 10 * time.Minute
+
+// badgeCharWidth approximates the width of each character in the badge's 11px font, for sizing its two halves.
+const badgeCharWidth = 7
+
+// the same colors as the running page's gauges: green on (or ahead of) target pace, and yellow when behind
+const (
+	badgeOnPace = "#18A551"
+	badgeBehind = "#CED82F"
+)
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Value}}">
+  <title>{{.Label}}: {{.Value}}</title>
+  <clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+  <g clip-path="url(#r)">
+    <rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+    <rect x="{{.LabelWidth}}" width="{{.ValueWidth}}" height="20" fill="{{.Color}}"/>
+  </g>
+  <g font-family="Verdana,DejaVu Sans,sans-serif" font-size="11" text-anchor="middle">
+    <text x="{{.LabelX}}" y="14" fill="#fff">{{.Label}}</text>
+    <text x="{{.ValueX}}" y="14" fill="#fff">{{.Value}}</text>
+  </g>
+</svg>
+`))
+
+// badgeView is a two-part badge, in the style of the usual README badges.
+type badgeView struct {
+	Label, Value, Color           string
+	Width, LabelWidth, ValueWidth int
+	LabelX, ValueX                float64
+}
+
+func newBadgeView(label, value, color string) *badgeView {
+	b := &badgeView{
+		Label:      label,
+		Value:      value,
+		Color:      color,
+		LabelWidth: utf8.RuneCountInString(label)*badgeCharWidth + 10,
+		ValueWidth: utf8.RuneCountInString(value)*badgeCharWidth + 10,
+	}
+	b.Width = b.LabelWidth + b.ValueWidth
+	b.LabelX = float64(b.LabelWidth) / 2
+	b.ValueX = float64(b.LabelWidth) + float64(b.ValueWidth)/2
+	return b
+}
+
+// BadgeCache holds rendered badges for badgeMaxAge, so that the revalidations of an embedded badge (every page view,
+// once it goes stale) don't each recompute the athlete's progress.  The zero value is an empty cache.
+type BadgeCache struct {
+	mu     sync.Mutex
+	badges map[string]*cachedBadge // by slug and year
+}
+
+type cachedBadge struct {
+	svg     []byte
+	etag    string
+	expires time.Time
+}
+
+func (c *BadgeCache) get(key string, now time.Time) *cachedBadge {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b := c.badges[key]; b != nil && now.Before(b.expires) {
+		return b
+	}
+	return nil
+}
+
+func (c *BadgeCache) put(key string, badge *cachedBadge, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.badges == nil {
+		c.badges = make(map[string]*cachedBadge)
+	}
+	for k, b := range c.badges {
+		if !now.Before(b.expires) {
+			delete(c.badges, k)
+		}
+	}
+	c.badges[key] = badge
+}
+
+// BadgeHandler serves a small SVG badge of an athlete's mileage against their goal, which can be embedded in a
+// README, forum signature or personal site.  Like the athlete's public page, it's identified by their share slug (as
+// the "u" param), and is only available while they have sharing turned on.
+func BadgeHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, cache *BadgeCache) {
+	settings, err := db.ReadShareSettingsBySlug(r.Context(), r.URL.Query().Get("u"))
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read share settings: %s", err)
+		return
+	}
+	if settings == nil || !settings.Enabled {
+		internal.HttpError(w, http.StatusNotFound, "no badge at %q", r.URL.String())
+		return
+	}
+
+	year := time.Now().Year()
+	if s := r.URL.Query().Get("year"); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			year = i
+		}
+	}
+
+	key := settings.Slug + "/" + strconv.Itoa(year)
+	now := time.Now()
+	badge := cache.get(key, now)
+	if badge == nil {
+		badge, err = renderBadge(r.Context(), settings.Username, year, db)
+		if err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to render badge: %s", err)
+			return
+		}
+		badge.expires = now.Add(badgeMaxAge)
+		cache.put(key, badge, now)
+	}
+
+	w.Header().Set("ETag", badge.etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%.0f", badgeMaxAge.Seconds()))
+	if r.Header.Get("If-None-Match") == badge.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	if _, err := w.Write(badge.svg); err != nil {
+		log.Printf("failed to write badge: %s", err)
+	}
+}
+
+// renderBadge draws the athlete's badge for the year, from their stored activities.
+func renderBadge(ctx context.Context, username string, year int, db *SqliteDb) (*cachedBadge, error) {
+	progress, err := loadSharedProgress(ctx, username, year, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress: %w", err)
+	}
+
+	value := fmt.Sprintf("%.0f/%s mi", progress.Miles, strconv.FormatFloat(progress.GoalMiles, 'f', -1, 64))
+	color := badgeBehind
+	if progress.HasPace() {
+		value += fmt.Sprintf(" · %.0f%%", progress.Progress)
+		if progress.GaugeRotate() >= 90 {
+			color = badgeOnPace
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := badgeTemplate.Execute(&buf, newBadgeView(fmt.Sprintf("running %d", year), value, color)); err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	return &cachedBadge{svg: buf.Bytes(), etag: `"` + hex.EncodeToString(sum[:]) + `"`}, nil
+}
@@ -0,0 +1,80 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+func TestBadgeHandler(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDb(t)
+	cache := &BadgeCache{}
+
+	now := time.Now().UTC()
+	err := db.WriteShareSettings(ctx, &storage.UpsertShareSettingsParams{Username: "athlete", Slug: "abc", Enabled: true,
+		UpdatedTime: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveRun := func(id int64, meters float64) {
+		run := testRun(id, now.Format("2006-01-02"), meters)
+		b, err := json.Marshal(&run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SaveActivities(ctx, "athlete", []json.RawMessage{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		BadgeHandler(rec, req, db, cache)
+		return rec
+	}
+
+	saveRun(1, 16093.44)
+	first := get("/running/badge.svg?u=abc", "")
+	if first.Code != http.StatusOK || !strings.Contains(first.Body.String(), ">10/") {
+		t.Fatalf("got status %d and badge %s, want 10 miles", first.Code, first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+
+	// until the badge expires, it's served from the cache, so a new run doesn't show yet
+	saveRun(2, 16093.44)
+	if rec := get("/running/badge.svg?u=abc", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation got status %d, want 304", rec.Code)
+	}
+	if rec := get("/running/badge.svg?u=abc", ""); rec.Body.String() != first.Body.String() {
+		t.Errorf("got badge %s, want the cached one", rec.Body.String())
+	}
+
+	// each year has its own badge
+	if rec := get("/running/badge.svg?u=abc&year=2020", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("badge for 2020 got status %d and ETag %s, want a different badge", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// badges expire, and aren't served once sharing is turned off
+	cache.badges["abc/"+strconv.Itoa(time.Now().Year())].expires = now
+	if rec := get("/running/badge.svg?u=abc", etag); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), ">20/") {
+		t.Errorf("after expiry got status %d and badge %s, want 20 miles", rec.Code, rec.Body.String())
+	}
+	err = db.WriteShareSettings(ctx, &storage.UpsertShareSettingsParams{Username: "athlete", Slug: "abc", UpdatedTime: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/running/badge.svg?u=abc", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("with sharing off got status %d, want 404", rec.Code)
+	}
+}
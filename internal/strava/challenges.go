@@ -0,0 +1,381 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// maxChallengeDays is the longest that a challenge may run for.
+const maxChallengeDays = 366
+
+// challengeSports are the activity types that a challenge may count.
+var challengeSports = []string{"Run", "Ride", "Walk", "Hike", "Swim"}
+
+// ReadChallenge returns the challenge with the given id, or nil if there is none.
+func (db *SqliteDb) ReadChallenge(ctx context.Context, id string) (*storage.StravaChallenge, error) {
+	row, err := db.query.FetchChallenge(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+// WriteChallenge creates a new challenge, with its owner as the first member.
+func (db *SqliteDb) WriteChallenge(ctx context.Context, challenge *storage.InsertChallengeParams) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	q := db.query.WithTx(tx)
+	if err := q.InsertChallenge(ctx, *challenge); err != nil {
+		return fmt.Errorf("failed to insert challenge: %w", err)
+	}
+
+	member := storage.InsertChallengeMemberParams{
+		ChallengeID: challenge.ID,
+		Username:    challenge.Owner,
+		JoinedTime:  challenge.CreatedTime,
+	}
+	if err := q.InsertChallengeMember(ctx, member); err != nil {
+		return fmt.Errorf("failed to insert challenge owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// challengeBounds returns the time range [start, finish) covered by the challenge; its end date is inclusive.
+func challengeBounds(challenge *storage.StravaChallenge) (time.Time, time.Time) {
+	start := challenge.StartDate.UTC()
+	end := challenge.EndDate.UTC().AddDate(0, 0, 1)
+	return start, end
+}
+
+// challengeLink is a challenge that the athlete belongs to, as listed on the running page.
+type challengeLink struct {
+	Name   string
+	Url    string
+	Dates  string
+	Status string
+}
+
+func newChallengeLinks(challenges []storage.StravaChallenge, now time.Time) []*challengeLink {
+	var links []*challengeLink
+	for i := range challenges {
+		challenge := &challenges[i]
+		links = append(links, &challengeLink{
+			Name:   challenge.Name,
+			Url:    "/running/challenges/" + challenge.ID,
+			Dates:  formatChallengeDates(challenge),
+			Status: challengeStatus(challenge, now),
+		})
+	}
+	return links
+}
+
+func formatChallengeDates(challenge *storage.StravaChallenge) string {
+	return challenge.StartDate.UTC().Format("Jan 2") + " – " + challenge.EndDate.UTC().Format("Jan 2, 2006")
+}
+
+// challengeStatus describes how long until the challenge starts or ends.
+func challengeStatus(challenge *storage.StravaChallenge, now time.Time) string {
+	start, end := challengeBounds(challenge)
+	switch {
+	case now.Before(start):
+		return fmt.Sprintf("starts in %s", pluralize(daysUntil(now, start), "day"))
+	case now.Before(end):
+		return fmt.Sprintf("%s left", pluralize(daysUntil(now, end), "day"))
+	default:
+		return "finished"
+	}
+}
+
+// daysUntil returns the number of days (rounded up) from now until t.
+func daysUntil(now, t time.Time) int {
+	return int(math.Ceil(t.Sub(now).Hours() / 24))
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// challengeRow is one member's standing on a challenge's progress board.
+type challengeRow struct {
+	Rank     int
+	Username string
+	Miles    float64
+	Count    int
+	Percent  float64
+	BarWidth float64 // percent, capped at 100
+	Finished bool
+	You      bool
+}
+
+// challengeProgress sums the member's stored activities of the challenge's sport, which are kept up to date by the
+// member's own visits and by stravasync.  Only activities that the athlete would show to others count, since the board
+// is visible to every member.
+func challengeProgress(ctx context.Context, db *SqliteDb, challenge *storage.StravaChallenge, username string, now time.Time) (*challengeRow, error) {
+	start, finish := challengeBounds(challenge)
+	if now.Before(finish) {
+		finish = now
+	}
+
+	row := &challengeRow{Username: username}
+	if !finish.After(start) {
+		return row, nil
+	}
+
+	activities, err := db.LoadActivities(ctx, username, start, finish)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions, err := db.ReadDuplicateDecisions(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read duplicate decisions: %w", err)
+	}
+
+	counted := decisions.Counted(activities)
+	for i := range counted {
+		activity := &counted[i]
+		if activity.Type != challenge.Sport || !isShareable(activity) {
+			continue
+		}
+		row.Miles += activity.Miles()
+		row.Count++
+	}
+	return row, nil
+}
+
+// ChallengesHandler serves the challenge pages: form posts to /running/challenges/ create a challenge,
+// /running/challenges/{id} is its progress board, and form posts to /running/challenges/{id}/join add the signed-in
+// athlete to it.
+func ChallengesHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	path := strings.TrimPrefix(r.URL.Path, "/running/challenges/")
+	switch {
+	case path == "":
+		createChallenge(w, r, db, account)
+	case strings.HasSuffix(path, "/join"):
+		joinChallenge(w, r, strings.TrimSuffix(path, "/join"), db, account)
+	default:
+		challengeBoard(w, r, tmpl, path, db)
+	}
+}
+
+func createChallenge(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		internal.HttpError(w, http.StatusBadRequest, "challenge needs a name")
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", r.PostFormValue("from"))
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid start date %q", r.PostFormValue("from"))
+		return
+	}
+	endDate, err := time.Parse("2006-01-02", r.PostFormValue("to"))
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid end date %q", r.PostFormValue("to"))
+		return
+	}
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= maxChallengeDays*24*time.Hour {
+		internal.HttpError(w, http.StatusBadRequest, "challenge must end after it starts, and last at most %d days", maxChallengeDays)
+		return
+	}
+
+	target, err := strconv.ParseFloat(strings.TrimSpace(r.PostFormValue("target")), 64)
+	if err != nil || target <= 0 {
+		internal.HttpError(w, http.StatusBadRequest, "invalid target distance %q", r.PostFormValue("target"))
+		return
+	}
+
+	sport := r.PostFormValue("sport")
+	if !contains(challengeSports, sport) {
+		internal.HttpError(w, http.StatusBadRequest, "unsupported sport %q", sport)
+		return
+	}
+
+	id, err := newShareSlug()
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to generate challenge id: %s", err)
+		return
+	}
+
+	arg := storage.InsertChallengeParams{
+		ID:          id,
+		Name:        name,
+		Owner:       username,
+		Sport:       sport,
+		StartDate:   startDate,
+		EndDate:     endDate,
+		TargetMiles: target,
+		CreatedTime: time.Now(),
+	}
+	if err := db.WriteChallenge(r.Context(), &arg); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write challenge: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, "/running/challenges/"+id, http.StatusSeeOther)
+}
+
+// joinChallenge adds the signed-in athlete to the challenge.  Athletes who aren't signed in are first sent through
+// Strava's authorization, which returns them to the challenge's board to join from there.
+func joinChallenge(w http.ResponseWriter, r *http.Request, id string, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	challenge, err := db.ReadChallenge(r.Context(), id)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read challenge: %s", err)
+		return
+	}
+	if challenge == nil {
+		internal.HttpError(w, http.StatusNotFound, "no challenge at %q", r.URL.Path)
+		return
+	}
+
+	username, err := signedInAthlete(r, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read session: %s", err)
+		return
+	}
+	if username == "" {
+		http.Redirect(w, r, getAuthUrlReturningTo(r, account, "/running/challenges/"+id), http.StatusSeeOther)
+		return
+	}
+
+	arg := storage.InsertChallengeMemberParams{
+		ChallengeID: id,
+		Username:    username,
+		JoinedTime:  time.Now(),
+	}
+	if err := db.query.InsertChallengeMember(r.Context(), arg); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to join challenge: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, "/running/challenges/"+id, http.StatusSeeOther)
+}
+
+// challengeBoard serves a challenge's progress board.  Anyone with the link may view it, so it only ever shows
+// usernames and totals.
+func challengeBoard(w http.ResponseWriter, r *http.Request, tmpl *template.Template, id string, db *SqliteDb) {
+	if r.Method != http.MethodGet {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	challenge, err := db.ReadChallenge(r.Context(), id)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read challenge: %s", err)
+		return
+	}
+	if challenge == nil {
+		internal.HttpError(w, http.StatusNotFound, "no challenge at %q", r.URL.Path)
+		return
+	}
+
+	members, err := db.query.ListChallengeMembers(r.Context(), id)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read challenge members: %s", err)
+		return
+	}
+
+	viewer, err := signedInAthlete(r, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read session: %s", err)
+		return
+	}
+	now := time.Now()
+
+	args := struct {
+		Name     string
+		Sport    string
+		Dates    string
+		Status   string
+		Target   string
+		Expected string // how far an evenly-paced member would be by now
+		Url      string
+		JoinUrl  string
+		IsMember bool
+		Rows     []*challengeRow
+	}{
+		Name:    challenge.Name,
+		Sport:   challenge.Sport,
+		Dates:   formatChallengeDates(challenge),
+		Status:  challengeStatus(challenge, now),
+		Target:  fmt.Sprintf("%.0f", challenge.TargetMiles),
+		Url:     "/running/challenges/" + id,
+		JoinUrl: "/running/challenges/" + id + "/join",
+	}
+
+	start, finish := challengeBounds(challenge)
+	if now.After(start) {
+		fraction := math.Min(1, now.Sub(start).Hours()/finish.Sub(start).Hours())
+		args.Expected = fmt.Sprintf("%.1f", fraction*challenge.TargetMiles)
+	}
+
+	for _, member := range members {
+		row, err := challengeProgress(r.Context(), db, challenge, member.Username, now)
+		if err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to read activities for %q: %s", member.Username, err)
+			return
+		}
+		row.Percent = 100 * row.Miles / challenge.TargetMiles
+		row.BarWidth = math.Min(100, roundTenth(row.Percent))
+		row.Finished = row.Miles >= challenge.TargetMiles
+		row.You = member.Username == viewer
+		args.IsMember = args.IsMember || row.You
+		args.Rows = append(args.Rows, row)
+	}
+
+	sort.SliceStable(args.Rows, func(i, j int) bool {
+		return args.Rows[i].Miles > args.Rows[j].Miles
+	})
+	for i, row := range args.Rows {
+		row.Rank = i + 1
+		if i > 0 && row.Miles == args.Rows[i-1].Miles {
+			row.Rank = args.Rows[i-1].Rank
+		}
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
@@ -0,0 +1,516 @@
+package strava
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+const (
+	// number of earlier years whose trends are compared with the selected year
+	chartTrendYears = 4
+
+	// runs shorter than this (in miles) are left out of the distribution charts, since their paces are mostly noise
+	minChartMiles = 0.5
+
+	// dimensions of the distribution charts, and the margins left for axis labels
+	histogramHeight = 150
+	scatterHeight   = 300
+	chartMarginLeft = 45
+	chartMarginTop  = 10
+	chartMarginEnd  = 10
+	chartMarginAxis = 20
+
+	// pace histogram bins, in seconds per mile
+	paceBinSeconds = 15
+)
+
+// trendColors are the colors of the trend lines in the scatter plot, the selected year first and then each earlier
+// year in turn.
+var trendColors = []string{"#CED82F", "#18A551", "#3D9BE8", "#E8A33D", "#B05FD1"}
+
+// chartRun is a run as plotted on the distribution charts.
+type chartRun struct {
+	Activity *Activity
+	Miles    float64
+	Pace     float64 // seconds per mile
+}
+
+// chartBar is one bar of a histogram, or a shaded band behind it.
+type chartBar struct {
+	X, Y, Width, Height float64
+	Title               string
+}
+
+// chartPoint is one run in the scatter plot.
+type chartPoint struct {
+	X, Y    float64
+	Title   string
+	Url     string
+	Outlier bool
+}
+
+// chartTick is a label along one of a chart's axes.
+type chartTick struct {
+	X, Y  float64
+	Label string
+}
+
+// trendLine is a least squares fit of pace against distance for one year's runs.
+type trendLine struct {
+	Year   int
+	Color  string
+	X1, Y1 float64
+	X2, Y2 float64
+}
+
+type histogramView struct {
+	Height int
+	Bars   []*chartBar
+	Band   *chartBar // the athlete's easy pace range, if known
+	XTicks []*chartTick
+	YTicks []*chartTick
+}
+
+type scatterView struct {
+	Height    int
+	Points    []*chartPoint
+	Trends    []*trendLine
+	XTicks    []*chartTick
+	YTicks    []*chartTick
+	AxisStart float64
+	AxisEnd   float64
+	AxisY     float64
+}
+
+// chartYear summarizes one year's runs, for comparing years.
+type chartYear struct {
+	Year        int
+	Color       string
+	Runs        int
+	MedianMiles string
+	MedianPace  string
+	Trend       string
+	EasyRange   string
+	EasyShare   string
+}
+
+// chartScale maps values in [min, max] linearly onto [lo, hi].
+type chartScale struct {
+	min, max float64
+	lo, hi   float64
+}
+
+func (s *chartScale) At(v float64) float64 {
+	if v < s.min {
+		v = s.min
+	}
+	if v > s.max {
+		v = s.max
+	}
+	if s.max == s.min {
+		return s.lo
+	}
+	return roundTenth(s.lo + (s.hi-s.lo)*(v-s.min)/(s.max-s.min))
+}
+
+// roundTenth rounds chart coordinates to keep the SVG small.
+func roundTenth(x float64) float64 {
+	return math.Round(10*x) / 10
+}
+
+// chartRuns returns the runs in [start, end) that can be plotted.
+func chartRuns(activities []Activity, start, end time.Time) []*chartRun {
+	var runs []*chartRun
+	for i := range activities {
+		activity := &activities[i]
+		t := activity.StartTime()
+		if activity.Type != "Run" || t.Before(start) || !t.Before(end) {
+			continue
+		}
+		if activity.Miles() < minChartMiles || activity.MovingTime <= 0 {
+			continue
+		}
+		runs = append(runs, &chartRun{Activity: activity, Miles: activity.Miles(), Pace: activity.MovingTime / activity.Miles()})
+	}
+	return runs
+}
+
+// quantile returns the q-th quantile of the (sorted) values, interpolating between neighbors.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+func sortedValues(runs []*chartRun, value func(*chartRun) float64) []float64 {
+	values := make([]float64, len(runs))
+	for i, run := range runs {
+		values[i] = value(run)
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// fitTrend returns a least squares fit of pace against distance, or false if there are too few runs (or too little
+// variety in their distances) for a fit to mean anything.
+func fitTrend(runs []*chartRun) (intercept, slope float64, ok bool) {
+	if len(runs) < 3 {
+		return 0, 0, false
+	}
+
+	var sumX, sumY, sumXX, sumXY float64
+	for _, run := range runs {
+		sumX += run.Miles
+		sumY += run.Pace
+		sumXX += run.Miles * run.Miles
+		sumXY += run.Miles * run.Pace
+	}
+	n := float64(len(runs))
+	denom := n*sumXX - sumX*sumX
+	if denom < 1e-9 {
+		return 0, 0, false
+	}
+	slope = (n*sumXY - sumX*sumY) / denom
+	return (sumY - slope*sumX) / n, slope, true
+}
+
+// findOutliers returns the runs whose paces are far from what the trend predicts for their distance, using Tukey's
+// fences on the residuals.
+func findOutliers(runs []*chartRun, intercept, slope float64) map[*chartRun]bool {
+	outliers := make(map[*chartRun]bool)
+	if len(runs) < 4 {
+		return outliers
+	}
+
+	residual := func(run *chartRun) float64 {
+		return run.Pace - (intercept + slope*run.Miles)
+	}
+	residuals := sortedValues(runs, residual)
+	q1, q3 := quantile(residuals, 0.25), quantile(residuals, 0.75)
+	lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+	for _, run := range runs {
+		if r := residual(run); r < lo || r > hi {
+			outliers[run] = true
+		}
+	}
+	return outliers
+}
+
+// easyPaceRange returns the athlete's easy pace range in seconds per mile (fastest first), per their VDOT as of end,
+// or false if there are no efforts to estimate VDOT from.
+func easyPaceRange(activities []Activity, end time.Time) (float64, float64, bool) {
+	var window []Activity
+	start := end.AddDate(0, 0, -vdotWindowDays)
+	for _, activity := range activities {
+		if t := activity.StartTime(); !t.Before(start) && t.Before(end) {
+			window = append(window, activity)
+		}
+	}
+
+	efforts, _ := bestVdotEfforts(window)
+	if len(efforts) == 0 {
+		return 0, 0, false
+	}
+	easy := vdotPaces[0]
+	v := efforts[0].Vdot
+	return metersPerMile / vdotSpeed(v, easy.Max), metersPerMile / vdotSpeed(v, easy.Min), true
+}
+
+// formatPaceSeconds formats a pace, in seconds per mile, as minutes:seconds.
+func formatPaceSeconds(secs float64) string {
+	s := int64(secs + 0.5)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// paceAxis returns the range of paces to show, in seconds per mile, which leaves out extreme outliers (like GPS
+// glitches) so that they don't squash everything else.  Those are drawn at the edge of the chart instead.
+func paceAxis(runs []*chartRun) (float64, float64) {
+	paces := sortedValues(runs, func(run *chartRun) float64 { return run.Pace })
+	q1, q3 := quantile(paces, 0.25), quantile(paces, 0.75)
+	lo, hi := math.Max(paces[0], q1-3*(q3-q1)), math.Min(paces[len(paces)-1], q3+3*(q3-q1))
+	lo = 30 * math.Floor(lo/30)
+	hi = 30 * math.Ceil(hi/30)
+	if hi <= lo {
+		hi = lo + 30
+	}
+	return lo, hi
+}
+
+// tickStep returns a step between axis labels that gives no more than maxTicks labels over span.
+func tickStep(span float64, steps []float64, maxTicks int) float64 {
+	for _, step := range steps {
+		if span/step <= float64(maxTicks) {
+			return step
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+func newHistogram(values []float64, binWidth, min, max float64, label func(float64) string, labelStep float64) *histogramView {
+	h := &histogramView{Height: histogramHeight}
+	bins := int(math.Ceil((max - min) / binWidth))
+	if bins < 1 {
+		bins = 1
+	}
+	counts := make([]int, bins)
+	for _, v := range values {
+		i := int((v - min) / binWidth)
+		if i < 0 {
+			i = 0
+		}
+		if i >= bins {
+			i = bins - 1
+		}
+		counts[i]++
+	}
+
+	maxCount := 1
+	for _, c := range counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	xs := &chartScale{min: min, max: min + float64(bins)*binWidth, lo: chartMarginLeft, hi: chartWidth - chartMarginEnd}
+	ys := &chartScale{min: 0, max: float64(maxCount), lo: histogramHeight - chartMarginAxis, hi: chartMarginTop}
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		lo := min + float64(i)*binWidth
+		h.Bars = append(h.Bars, &chartBar{
+			X:      xs.At(lo) + 1,
+			Y:      ys.At(float64(c)),
+			Width:  roundTenth(xs.At(lo+binWidth) - xs.At(lo) - 2),
+			Height: roundTenth(ys.At(0) - ys.At(float64(c))),
+			Title:  fmt.Sprintf("%s to %s: %d runs", label(lo), label(lo+binWidth), c),
+		})
+	}
+
+	for v := math.Ceil(min/labelStep) * labelStep; v <= xs.max; v += labelStep {
+		h.XTicks = append(h.XTicks, &chartTick{X: xs.At(v), Y: histogramHeight - 5, Label: label(v)})
+	}
+	countStep := tickStep(float64(maxCount), []float64{1, 2, 5, 10, 20, 50, 100}, 4)
+	for c := 0.0; c <= float64(maxCount); c += countStep {
+		h.YTicks = append(h.YTicks, &chartTick{X: chartMarginLeft - 5, Y: ys.At(c) + 4, Label: strconv.Itoa(int(c))})
+	}
+	return h
+}
+
+// newChartYear summarizes a year's runs; the trend is how much slower (or faster) runs get per extra mile.
+func newChartYear(year int, runs []*chartRun, activities []Activity, end time.Time) *chartYear {
+	y := &chartYear{Year: year, Runs: len(runs), MedianMiles: "-", MedianPace: "-", Trend: "-", EasyRange: "-", EasyShare: "-"}
+	if len(runs) == 0 {
+		return y
+	}
+
+	y.MedianMiles = fmt.Sprintf("%.1f", quantile(sortedValues(runs, func(run *chartRun) float64 { return run.Miles }), 0.5))
+	y.MedianPace = formatPaceSeconds(quantile(sortedValues(runs, func(run *chartRun) float64 { return run.Pace }), 0.5))
+	if _, slope, ok := fitTrend(runs); ok {
+		y.Trend = fmt.Sprintf("%+.0fs", slope)
+	}
+
+	if fastest, slowest, ok := easyPaceRange(activities, end); ok {
+		y.EasyRange = formatPaceSeconds(fastest) + "-" + formatPaceSeconds(slowest)
+
+		// races (and anything else faster than easy) count against the share, as easy running is the point
+		var easy int
+		for _, run := range runs {
+			if run.Pace >= fastest {
+				easy++
+			}
+		}
+		y.EasyShare = fmt.Sprintf("%.0f%%", 100*float64(easy)/float64(len(runs)))
+	}
+	return y
+}
+
+// ChartsHandler serves histograms of the athlete's run distances and paces over a year, plus a scatter plot of pace
+// against distance.  The scatter plot highlights outlier runs and compares the year's trend with earlier years', and
+// the athlete's easy pace range (from their VDOT) is marked so that they can see whether their easy runs are easy.
+func ChartsHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	year := time.Now().Year()
+	if s := r.URL.Query().Get("year"); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			year = i
+		}
+	}
+
+	now := time.Now()
+	queryStart, queryEnd, _ := yearBounds(year, now)
+	fetchStart := time.Date(year-chartTrendYears, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	activities, err := syncActivities(r.Context(), username, fetchStart, queryEnd, db, account)
+	if err != nil {
+		if errors.Is(err, ErrNeedsAuth) {
+			http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+			return
+		}
+		internal.HttpError(w, http.StatusInternalServerError, "failed to query strava: %s", err)
+		return
+	}
+
+	decisions, err := db.ReadDuplicateDecisions(r.Context(), username)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read duplicate decisions: %s", err)
+		return
+	}
+	activities = decisions.Counted(activities)
+
+	args := struct {
+		Year           int
+		VdotWindowDays int
+		PrevUrl        string
+		NextUrl        string
+		RunCount       int
+		ChartWidth     int
+		Distances      *histogramView
+		Paces          *histogramView
+		Scatter        *scatterView
+		Outliers       []*activityRow
+		Years          []*chartYear
+	}{
+		Year:           year,
+		VdotWindowDays: vdotWindowDays,
+		PrevUrl:        fmt.Sprintf("%s?year=%d", r.URL.Path, year-1),
+		ChartWidth:     chartWidth,
+	}
+	if year < now.Year() {
+		args.NextUrl = fmt.Sprintf("%s?year=%d", r.URL.Path, year+1)
+	}
+
+	runs := chartRuns(activities, queryStart, queryEnd)
+	args.RunCount = len(runs)
+
+	// compare with earlier years, newest first
+	yearRuns := make(map[int][]*chartRun)
+	for i := 0; i <= chartTrendYears; i++ {
+		y := year - i
+		start := time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(1, 0, 0)
+		if i == 0 {
+			end = queryEnd
+		}
+		yearRuns[y] = chartRuns(activities, start, end)
+
+		summary := newChartYear(y, yearRuns[y], activities, end)
+		summary.Color = trendColors[i%len(trendColors)]
+		args.Years = append(args.Years, summary)
+	}
+
+	if len(runs) > 0 {
+		// distance histogram, in 1 mile bins (or wider for ultrarunners)
+		distances := sortedValues(runs, func(run *chartRun) float64 { return run.Miles })
+		maxMiles := math.Ceil(distances[len(distances)-1])
+		binMiles := tickStep(maxMiles, []float64{1, 2, 5, 10}, 30)
+		args.Distances = newHistogram(distances, binMiles, 0, maxMiles, func(v float64) string {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}, tickStep(maxMiles, []float64{1, 2, 5, 10, 20, 50}, 10))
+
+		// pace histogram, slowest on the left so that faster is to the right like the distance histogram
+		minPace, maxPace := paceAxis(runs)
+		negPaces := sortedValues(runs, func(run *chartRun) float64 { return -run.Pace })
+		paceLabel := func(v float64) string { return formatPaceSeconds(-v) }
+		args.Paces = newHistogram(negPaces, paceBinSeconds, -maxPace, -minPace, paceLabel,
+			tickStep(maxPace-minPace, []float64{30, 60, 120, 300}, 8))
+
+		xs := &chartScale{min: -maxPace, max: -minPace, lo: chartMarginLeft, hi: chartWidth - chartMarginEnd}
+		xs.max = xs.min + math.Ceil((xs.max-xs.min)/paceBinSeconds)*paceBinSeconds // match the histogram's bins
+		if fastest, slowest, ok := easyPaceRange(activities, queryEnd); ok {
+			x1, x2 := xs.At(-slowest), xs.At(-fastest)
+			args.Paces.Band = &chartBar{
+				X:      x1,
+				Y:      chartMarginTop,
+				Width:  roundTenth(x2 - x1),
+				Height: histogramHeight - chartMarginAxis - chartMarginTop,
+				Title:  fmt.Sprintf("easy pace: %s to %s", formatPaceSeconds(fastest), formatPaceSeconds(slowest)),
+			}
+		}
+
+		intercept, slope, _ := fitTrend(runs)
+		outliers := findOutliers(runs, intercept, slope)
+		args.Scatter = newScatter(runs, outliers, yearRuns, year, maxMiles, minPace, maxPace)
+		for _, run := range runs {
+			if outliers[run] {
+				args.Outliers = append(args.Outliers, newActivityRow(run.Activity))
+			}
+		}
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
+
+// newScatter plots pace against distance for the selected year's runs, with a trend line for the selected year and
+// each earlier year that has enough runs.  Faster paces are plotted higher.
+func newScatter(runs []*chartRun, outliers map[*chartRun]bool, yearRuns map[int][]*chartRun, year int, maxMiles, minPace, maxPace float64) *scatterView {
+	s := &scatterView{
+		Height:    scatterHeight,
+		AxisStart: chartMarginLeft,
+		AxisEnd:   chartWidth - chartMarginEnd,
+		AxisY:     scatterHeight - chartMarginAxis,
+	}
+	xs := &chartScale{min: 0, max: maxMiles, lo: chartMarginLeft, hi: chartWidth - chartMarginEnd}
+	ys := &chartScale{min: minPace, max: maxPace, lo: chartMarginTop, hi: scatterHeight - chartMarginAxis}
+
+	for _, run := range runs {
+		s.Points = append(s.Points, &chartPoint{
+			X: xs.At(run.Miles),
+			Y: ys.At(run.Pace),
+			Title: fmt.Sprintf("%s: %.1f miles at %s pace", run.Activity.Name, run.Miles,
+				formatPaceSeconds(run.Pace)),
+			Url:     fmt.Sprintf("/running/activity/%d", run.Activity.Id),
+			Outlier: outliers[run],
+		})
+	}
+
+	// draw older years first, so that the selected year is on top
+	for i := chartTrendYears; i >= 0; i-- {
+		y := year - i
+		intercept, slope, ok := fitTrend(yearRuns[y])
+		if !ok {
+			continue
+		}
+
+		distances := sortedValues(yearRuns[y], func(run *chartRun) float64 { return run.Miles })
+		lo, hi := distances[0], math.Min(distances[len(distances)-1], maxMiles)
+		s.Trends = append(s.Trends, &trendLine{
+			Year:  y,
+			Color: trendColors[i%len(trendColors)],
+			X1:    xs.At(lo),
+			Y1:    ys.At(intercept + slope*lo),
+			X2:    xs.At(hi),
+			Y2:    ys.At(intercept + slope*hi),
+		})
+	}
+
+	for v := 0.0; v <= maxMiles; v += tickStep(maxMiles, []float64{1, 2, 5, 10, 20, 50}, 10) {
+		s.XTicks = append(s.XTicks, &chartTick{X: xs.At(v), Y: scatterHeight - 5, Label: strconv.FormatFloat(v, 'f', -1, 64)})
+	}
+	paceStep := tickStep(maxPace-minPace, []float64{30, 60, 120, 300}, 8)
+	for v := minPace; v <= maxPace; v += paceStep {
+		s.YTicks = append(s.YTicks, &chartTick{X: chartMarginLeft - 5, Y: ys.At(v) + 4, Label: formatPaceSeconds(v)})
+	}
+	return s
+}
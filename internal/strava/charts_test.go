@@ -0,0 +1,86 @@
+package strava
+
+import (
+	"math"
+	"testing"
+)
+
+// testChartRuns returns runs of the given distances, each with the given pace in seconds per mile.
+func testChartRuns(miles []float64, paces []float64) []*chartRun {
+	runs := make([]*chartRun, len(miles))
+	for i := range miles {
+		runs[i] = &chartRun{Activity: &Activity{Id: int64(i + 1)}, Miles: miles[i], Pace: paces[i]}
+	}
+	return runs
+}
+
+func TestFitTrend(t *testing.T) {
+	tests := []struct {
+		name          string
+		miles, paces  []float64
+		wantIntercept float64
+		wantSlope     float64
+		wantOk        bool
+	}{
+		{"exact line", []float64{3, 5, 8}, []float64{630, 650, 680}, 600, 10, true},
+		{"flat", []float64{2, 4, 6, 8}, []float64{540, 540, 540, 540}, 540, 0, true},
+		{"noisy", []float64{1, 2, 3, 4}, []float64{500, 520, 500, 520}, 500, 4, true},
+		{"too few runs", []float64{3, 5}, []float64{630, 650}, 0, 0, false},
+		{"all the same distance", []float64{5, 5, 5}, []float64{600, 620, 640}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intercept, slope, ok := fitTrend(testChartRuns(tt.miles, tt.paces))
+			if ok != tt.wantOk || math.Abs(intercept-tt.wantIntercept) > 1e-6 || math.Abs(slope-tt.wantSlope) > 1e-6 {
+				t.Errorf("fitTrend() = %v, %v, %t, want %v, %v, %t", intercept, slope, ok, tt.wantIntercept,
+					tt.wantSlope, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestFindOutliers(t *testing.T) {
+	tests := []struct {
+		name         string
+		miles, paces []float64
+		want         []int64 // activity ids
+	}{
+		{
+			name:  "one slow run",
+			miles: []float64{1, 2, 3, 4, 5, 6},
+			paces: []float64{605, 617, 630, 642, 654, 750}, // residuals -5, -3, 0, 2, 4, 90
+			want:  []int64{6},
+		},
+		{
+			name:  "one fast run",
+			miles: []float64{1, 2, 3, 4, 5, 6},
+			paces: []float64{605, 617, 630, 642, 654, 560},
+			want:  []int64{6},
+		},
+		{
+			name:  "all close to the trend",
+			miles: []float64{1, 2, 3, 4, 5, 6},
+			paces: []float64{605, 617, 630, 642, 654, 662},
+		},
+		{
+			name:  "too few runs",
+			miles: []float64{1, 2, 3},
+			paces: []float64{610, 620, 900},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the trend is 600s/mile plus 10s for every mile
+			outliers := findOutliers(testChartRuns(tt.miles, tt.paces), 600, 10)
+			var got []int64
+			for run := range outliers {
+				got = append(got, run.Activity.Id)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("findOutliers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
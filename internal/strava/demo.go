@@ -0,0 +1,431 @@
+package strava
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+// Demo mode serves generated fixture athletes and activities through an in-process fake of the Strava API, so that
+// the running pages can be developed and demoed without credentials or network access.
+
+const (
+	// local page that stands in for Strava's OAuth authorization page in demo mode
+	demoAuthorizePath = "/strava/demo/authorize"
+
+	// how much history is generated for each fixture athlete
+	demoHistoryDays = 3 * 365
+
+	demoAccessPrefix  = "demo-access:"
+	demoRefreshPrefix = "demo-refresh:"
+	demoCodePrefix    = "demo-code:"
+
+	// added to the ids of duplicate uploads (see demoAthlete.DuplicateEvery)
+	demoDuplicateOffset = 500000
+)
+
+// all fixture activities are generated relative to this date, so that ids are stable
+var demoEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type demoAthlete struct {
+	Username    string
+	RunsPerWeek float64
+	BaseMeters  float64 // distance of a typical easy run
+	Speed       float64 // speed of a typical easy run, in meters/second
+	Hilliness   float64 // meters of climbing per km
+	RaceWeeks   int64   // races every this many weeks (on Saturdays), or never if 0
+
+	// runs on every this many days are uploaded twice, as if from both a watch and a phone app; never if 0
+	DuplicateEvery int64
+}
+
+var demoAthletes = []demoAthlete{
+	{Username: "demo-jogger", RunsPerWeek: 3, BaseMeters: 5000, Speed: 2.7, Hilliness: 8, DuplicateEvery: 17},
+	{Username: "demo-racer", RunsPerWeek: 5.5, BaseMeters: 10000, Speed: 3.4, Hilliness: 12, RaceWeeks: 8},
+	{Username: "demo-ultra", RunsPerWeek: 6, BaseMeters: 14000, Speed: 3.0, Hilliness: 30, RaceWeeks: 12},
+}
+
+var demoAuthorizeTemplate = template.Must(template.New("demo").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <link type="text/css" href="/css/strava.css" rel="stylesheet" media="screen">
+  </head>
+  <body>
+    <div id="main-content">
+      <div id="intro">
+        <h2>Demo mode</h2>
+        <p>Pick a fixture athlete to sign in as:</p>
+        {{range .}}<p><a href="/strava/exchange_token/?code={{.Code}}{{if .State}}&state={{.State}}{{end}}">{{.Username}}</a></p>{{end}}
+      </div>
+    </div>
+  </body>
+</html>
+`))
+
+// DemoAuthorizeHandler stands in for Strava's OAuth authorization page in demo mode, redirecting back to the token
+// exchange with a code for the chosen fixture athlete (and the OAuth state, if any).
+func DemoAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	type choice struct {
+		Username string
+		Code     string
+		State    string
+	}
+
+	var choices []choice
+	for _, athlete := range demoAthletes {
+		choices = append(choices, choice{
+			Username: athlete.Username,
+			Code:     demoCodePrefix + athlete.Username,
+			State:    r.URL.Query().Get("state"),
+		})
+	}
+
+	if err := demoAuthorizeTemplate.Execute(w, choices); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
+
+// NewDemoClient returns an http client that serves requests to the Strava API from generated fixtures, without
+// touching the network.
+func NewDemoClient() *http.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", demoTokenHandler)
+	mux.HandleFunc("/api/v3/oauth/token", demoTokenHandler)
+	mux.HandleFunc("/api/v3/athlete", demoProfileHandler)
+	mux.HandleFunc("/api/v3/athlete/activities", demoActivitiesHandler)
+	mux.HandleFunc("/api/v3/activities/", demoActivityHandler)
+
+	return &http.Client{Transport: &demoTransport{handler: mux}}
+}
+
+type demoTransport struct {
+	handler http.Handler
+}
+
+func (t *demoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-RateLimit-Limit", "600,30000")
+	w.Header().Set("X-RateLimit-Usage", "0,0")
+	t.handler.ServeHTTP(w, req)
+	rsp := w.Result()
+	rsp.Request = req
+	return rsp, nil
+}
+
+func demoTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var username string
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		username = strings.TrimPrefix(r.PostFormValue("code"), demoCodePrefix)
+	case "refresh_token":
+		username = strings.TrimPrefix(r.PostFormValue("refresh_token"), demoRefreshPrefix)
+	}
+
+	if findDemoAthlete(username) < 0 {
+		http.Error(w, "unknown demo athlete", http.StatusBadRequest)
+		return
+	}
+
+	writeDemoJson(w, &AuthResponse{
+		TokenType:    "Bearer",
+		ExpiresAt:    time.Now().Add(6 * time.Hour).Unix(),
+		RefreshToken: demoRefreshPrefix + username,
+		AccessToken:  demoAccessPrefix + username,
+	})
+}
+
+func demoProfileHandler(w http.ResponseWriter, r *http.Request) {
+	idx := demoAthleteFromRequest(w, r)
+	if idx < 0 {
+		return
+	}
+	writeDemoJson(w, &ProfileInfo{Username: demoAthletes[idx].Username})
+}
+
+func demoActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	idx := demoAthleteFromRequest(w, r)
+	if idx < 0 {
+		return
+	}
+
+	qs := r.URL.Query()
+	now := time.Now().UTC()
+	start := now.AddDate(0, 0, -demoHistoryDays)
+	finish := now
+	if s := qs.Get("after"); s != "" {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil && time.Unix(i, 0).After(start) {
+			start = time.Unix(i, 0).UTC()
+		}
+	}
+	if s := qs.Get("before"); s != "" {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil && time.Unix(i, 0).Before(finish) {
+			finish = time.Unix(i, 0).UTC()
+		}
+	}
+
+	activities := []Activity{}
+	for day := start.Truncate(24 * time.Hour); day.Before(finish); day = day.AddDate(0, 0, 1) {
+		activity, ok := demoActivity(idx, day, false)
+		if !ok {
+			continue
+		}
+		if t := activity.StartTime(); t.After(start) && t.Before(finish) {
+			activities = append(activities, activity.Activity)
+			if every := demoAthletes[idx].DuplicateEvery; every > 0 && (activity.Id%1000000)%every == 0 {
+				activities = append(activities, demoDuplicate(activity.Activity))
+			}
+		}
+	}
+
+	// like Strava, return oldest first when only given a lower bound, and newest first otherwise
+	if qs.Get("after") == "" || qs.Get("before") != "" {
+		sort.Slice(activities, func(i, j int) bool { return activities[i].StartDate > activities[j].StartDate })
+	}
+
+	perPage, page := 30, 1
+	if i, err := strconv.Atoi(qs.Get("per_page")); err == nil && i > 0 {
+		perPage = i
+	}
+	if i, err := strconv.Atoi(qs.Get("page")); err == nil && i > 0 {
+		page = i
+	}
+
+	lo := (page - 1) * perPage
+	if lo > len(activities) {
+		lo = len(activities)
+	}
+	hi := lo + perPage
+	if hi > len(activities) {
+		hi = len(activities)
+	}
+	writeDemoJson(w, activities[lo:hi])
+}
+
+func demoActivityHandler(w http.ResponseWriter, r *http.Request) {
+	idx := demoAthleteFromRequest(w, r)
+	if idx < 0 {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v3/activities/")
+	wantStreams := strings.HasSuffix(path, "/streams")
+	id, err := strconv.ParseInt(strings.TrimSuffix(path, "/streams"), 10, 64)
+	if err != nil || int(id/1000000)-1 != idx {
+		http.Error(w, "Record Not Found", http.StatusNotFound)
+		return
+	}
+
+	dayNum := id % 1000000
+	activity, ok := demoActivity(idx, demoEpoch.AddDate(0, 0, int(dayNum%demoDuplicateOffset)), true)
+	if !ok {
+		http.Error(w, "Record Not Found", http.StatusNotFound)
+		return
+	}
+	if dayNum >= demoDuplicateOffset {
+		activity.Activity = demoDuplicate(activity.Activity)
+	}
+
+	if wantStreams {
+		writeDemoJson(w, activity.streams)
+	} else {
+		writeDemoJson(w, activity.ActivityDetail)
+	}
+}
+
+// demoAthleteFromRequest returns the index of the fixture athlete whose access token authorized r, or writes an error
+// and returns -1.
+func demoAthleteFromRequest(w http.ResponseWriter, r *http.Request) int {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	idx := findDemoAthlete(strings.TrimPrefix(token, demoAccessPrefix))
+	if idx < 0 || !strings.HasPrefix(token, demoAccessPrefix) {
+		http.Error(w, "Authorization Error", http.StatusUnauthorized)
+		return -1
+	}
+	return idx
+}
+
+func findDemoAthlete(username string) int {
+	for i, athlete := range demoAthletes {
+		if athlete.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeDemoJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type demoGenerated struct {
+	ActivityDetail
+	streams *ActivityStreams
+}
+
+// demoActivity generates the given athlete's activity (if any) on the given day, optionally with its streams, splits
+// and laps.  Everything is derived from a random source seeded with the athlete and day, so the same activity is
+// generated every time.
+func demoActivity(idx int, day time.Time, withStreams bool) (*demoGenerated, bool) {
+	athlete := &demoAthletes[idx]
+	dayNum := int64(day.Sub(demoEpoch).Hours() / 24)
+	week := dayNum / 7
+	rnd := rand.New(rand.NewSource(int64(idx+1)*1000003 + dayNum))
+
+	weekday := day.Weekday()
+	race := athlete.RaceWeeks > 0 && week%athlete.RaceWeeks == 0 && weekday == time.Saturday
+	long := !race && weekday == time.Sunday && athlete.RunsPerWeek >= 3
+	workout := !race && weekday == time.Tuesday && athlete.RunsPerWeek >= 5
+	if !race && !long && !workout && rnd.Float64() > athlete.RunsPerWeek/7 {
+		return nil, false
+	}
+
+	name := "Morning Run"
+	workoutType := WorkoutTypeDefault
+	distance := athlete.BaseMeters * (0.7 + 0.6*rnd.Float64())
+	speed := athlete.Speed * (0.95 + 0.1*rnd.Float64())
+	heartrate := 138 + 8*rnd.Float64()
+
+	switch {
+	case race:
+		distance = []float64{5000, 10000, 21097.5}[(week/athlete.RaceWeeks)%3]
+		speed = 1.25 * athlete.Speed * math.Pow(10000/distance, 0.06)
+		heartrate = 168 + 6*rnd.Float64()
+		workoutType = WorkoutTypeRace
+		name = formatRaceDistance(distance) + " Race"
+	case long:
+		distance = 2 * athlete.BaseMeters * (0.9 + 0.3*rnd.Float64())
+		speed *= 0.95
+		heartrate += 5
+		workoutType = WorkoutTypeLongRun
+		name = "Sunday Long Run"
+	case workout:
+		distance = 1.1 * athlete.BaseMeters
+		speed *= 1.12
+		heartrate = 158 + 6*rnd.Float64()
+		workoutType = WorkoutTypeWorkout
+		name = "Tempo Tuesday"
+	}
+
+	movingTime := math.Round(distance / speed)
+	elapsedTime := math.Round(movingTime * (1.01 + 0.05*rnd.Float64()))
+	if race {
+		elapsedTime = movingTime
+	}
+
+	startDate := day.Add(6*time.Hour + 30*time.Minute + time.Duration(rnd.Intn(90))*time.Minute)
+
+	activity := Activity{
+		Id:                 int64(idx+1)*1000000 + dayNum,
+		Name:               name,
+		DistanceMeters:     math.Round(distance*10) / 10,
+		MovingTime:         movingTime,
+		ElapsedTime:        elapsedTime,
+		TotalElevationGain: math.Round(athlete.Hilliness * distance / 1000 * (0.5 + rnd.Float64())),
+		Type:               "Run",
+		StartDate:          startDate.Format(time.RFC3339),
+		AverageHeartrate:   math.Round(heartrate*10) / 10,
+		MaxHeartrate:       math.Round(heartrate + 8 + 10*rnd.Float64()),
+		AverageCadence:     math.Round((80+4*speed/athlete.Speed+2*rnd.Float64())*10) / 10,
+		SufferScore:        math.Round(movingTime / 60 * (heartrate - 100) / 40),
+		WorkoutType:        workoutType,
+		Private:            rnd.Float64() < 0.03,
+		HideFromHome:       rnd.Float64() < 0.03,
+	}
+
+	generated := &demoGenerated{ActivityDetail: ActivityDetail{Activity: activity}}
+	if withStreams {
+		generated.generateStreams(rnd, speed)
+	}
+	return generated, true
+}
+
+// demoDuplicate returns a second upload of the given activity, which (like a phone app's recording of a run that a
+// watch also recorded) starts a little later and measures a slightly different distance.
+func demoDuplicate(activity Activity) Activity {
+	dup := activity
+	dup.Id += demoDuplicateOffset
+	dup.StartDate = activity.StartTime().Add(20 * time.Second).Format(time.RFC3339)
+	dup.DistanceMeters = math.Round(activity.DistanceMeters*98.5) / 100
+	dup.MovingTime = activity.MovingTime - 20
+	dup.ElapsedTime = activity.ElapsedTime - 20
+	return dup
+}
+
+// generateStreams fills in streams, splits and laps for the activity, consistent with its summary stats.
+func (g *demoGenerated) generateStreams(rnd *rand.Rand, speed float64) {
+	n := int(g.DistanceMeters/25) + 2
+	distance := make([]float64, n)
+	altitude := make([]float64, n)
+	velocity := make([]float64, n)
+
+	hills := 1 + rnd.Float64()*3
+	amplitude := g.TotalElevationGain / (4 * hills)
+	phase := rnd.Float64() * 2 * math.Pi
+	for i := 0; i < n; i++ {
+		frac := float64(i) / float64(n-1)
+		distance[i] = math.Round(frac * g.DistanceMeters)
+		altitude[i] = math.Round((100+amplitude*math.Sin(phase+2*math.Pi*hills*frac))*10) / 10
+		velocity[i] = math.Round(speed*(1+0.05*math.Sin(float64(i)/15)+0.06*(rnd.Float64()-0.5))*100) / 100
+	}
+
+	g.streams = &ActivityStreams{
+		Distance:       &Stream{Data: distance},
+		Altitude:       &Stream{Data: altitude},
+		VelocitySmooth: &Stream{Data: velocity},
+	}
+
+	altitudeAt := func(meters float64) float64 {
+		i := int(meters / g.DistanceMeters * float64(n-1))
+		if i >= n {
+			i = n - 1
+		}
+		return altitude[i]
+	}
+
+	makeSplits := func(unitMeters float64) []Split {
+		var splits []Split
+		for start := 0.0; start < g.DistanceMeters-1; start += unitMeters {
+			d := math.Min(unitMeters, g.DistanceMeters-start)
+			splitSpeed := speed * (0.97 + 0.06*rnd.Float64())
+			splits = append(splits, Split{
+				Split:               len(splits) + 1,
+				DistanceMeters:      math.Round(d*10) / 10,
+				MovingTime:          math.Round(d / splitSpeed),
+				ElapsedTime:         math.Round(d / splitSpeed),
+				ElevationDifference: altitudeAt(start+d) - altitudeAt(start),
+				AverageSpeed:        math.Round(splitSpeed*100) / 100,
+				AverageHeartrate:    math.Round(g.AverageHeartrate + 4*(rnd.Float64()-0.5)),
+			})
+		}
+		return splits
+	}
+
+	g.SplitsMetric = makeSplits(metersPerKm)
+	g.SplitsStandard = makeSplits(metersPerMile)
+	for i, split := range g.SplitsStandard {
+		g.Laps = append(g.Laps, Lap{
+			Name:               fmt.Sprintf("Lap %d", i+1),
+			LapIndex:           i + 1,
+			DistanceMeters:     split.DistanceMeters,
+			MovingTime:         split.MovingTime,
+			ElapsedTime:        split.ElapsedTime,
+			TotalElevationGain: math.Max(0, split.ElevationDifference),
+			AverageSpeed:       split.AverageSpeed,
+			AverageHeartrate:   split.AverageHeartrate,
+		})
+	}
+}
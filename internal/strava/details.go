@@ -0,0 +1,398 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	metersPerMile = 1609.344
+	metersPerKm   = 1000.
+
+	// dimensions of the profile charts on the activity page
+	chartWidth  = 600
+	chartHeight = 150
+
+	// streams are downsampled to (at most) this many points before being drawn
+	maxChartPoints = 300
+
+	// speeds below this (in meters/second) are clamped when drawing pace profiles, so that stops don't dominate the
+	// scale of the chart
+	minChartSpeed = 1.0
+)
+
+type Split struct {
+	Split               int     `json:"split"`
+	DistanceMeters      float64 `json:"distance"`
+	MovingTime          float64 `json:"moving_time"`
+	ElapsedTime         float64 `json:"elapsed_time"`
+	ElevationDifference float64 `json:"elevation_difference"`
+	AverageSpeed        float64 `json:"average_speed"`
+	AverageHeartrate    float64 `json:"average_heartrate"`
+}
+
+type Lap struct {
+	Name               string  `json:"name"`
+	LapIndex           int     `json:"lap_index"`
+	DistanceMeters     float64 `json:"distance"`
+	MovingTime         float64 `json:"moving_time"`
+	ElapsedTime        float64 `json:"elapsed_time"`
+	TotalElevationGain float64 `json:"total_elevation_gain"`
+	AverageSpeed       float64 `json:"average_speed"`
+	AverageHeartrate   float64 `json:"average_heartrate"`
+}
+
+// ActivityDetail is the "detailed" representation of an activity, as returned by the /activities/{id} endpoint.
+type ActivityDetail struct {
+	Activity
+	SplitsMetric   []Split `json:"splits_metric"`
+	SplitsStandard []Split `json:"splits_standard"`
+	Laps           []Lap   `json:"laps"`
+}
+
+type Stream struct {
+	Data []float64 `json:"data"`
+}
+
+// ActivityStreams holds the subset of an activity's streams that we draw, keyed by stream type.
+type ActivityStreams struct {
+	Distance       *Stream `json:"distance"`
+	Altitude       *Stream `json:"altitude"`
+	VelocitySmooth *Stream `json:"velocity_smooth"`
+}
+
+// ReadActivityDetails returns the details and streams for an activity that the athlete has cached, or nils if they
+// haven't cached it yet.
+func (db *SqliteDb) ReadActivityDetails(ctx context.Context, username string, activityId int64) (*storage.FetchActivityDetailsRow, error) {
+	row, err := db.query.FetchActivityDetails(ctx, storage.FetchActivityDetailsParams{
+		Username:   username,
+		ActivityID: activityId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &row, nil
+}
+
+func (db *SqliteDb) WriteActivityDetails(ctx context.Context, details *storage.InsertActivityDetailsParams) error {
+	return db.query.InsertActivityDetails(ctx, *details)
+}
+
+// ActivityHandler serves a page showing a single activity, with its splits, laps and pace/elevation profiles.
+func ActivityHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	activityId, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/running/activity/"), 10, 64)
+	if err != nil {
+		internal.HttpError(w, http.StatusNotFound, "invalid activity id in path %q", r.URL.Path)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	detail, streams, err := getActivityDetails(r.Context(), username, activityId, r.URL.Query().Get("refresh") == "1", db, account)
+	if err != nil {
+		if err == ErrNeedsAuth {
+			http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+			return
+		}
+
+		var herr *internal.Error
+		if errors.As(err, &herr) && herr.IsNotFound() {
+			internal.HttpError(w, http.StatusNotFound, "activity %d not found", activityId)
+			return
+		}
+
+		internal.HttpError(w, http.StatusInternalServerError, "failed to get activity details: %s", err)
+		return
+	}
+
+	units := "mi"
+	unitMeters := metersPerMile
+	splits := detail.SplitsStandard
+	if r.URL.Query().Get("units") == "km" {
+		units = "km"
+		unitMeters = metersPerKm
+		splits = detail.SplitsMetric
+	}
+
+	qs := r.URL.Query()
+	qs.Del("refresh")
+	if units == "km" {
+		qs.Del("units")
+	} else {
+		qs.Set("units", "km")
+	}
+
+	entry, err := db.ReadJournalEntry(r.Context(), username, activityId)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read journal entry: %s", err)
+		return
+	}
+
+	type row struct {
+		Name      string
+		Distance  string
+		Time      string
+		Pace      string
+		Elevation string
+		Heartrate string
+	}
+
+	args := struct {
+		Activity        *activityRow
+		StravaUrl       string
+		Units           string
+		UnitsToggleUrl  string
+		Splits          []*row
+		Laps            []*row
+		ChartWidth      int
+		ChartHeight     int
+		PacePoints      string
+		PaceFastest     string
+		PaceSlowest     string
+		ElevationPoints string
+		ElevationMin    string
+		ElevationMax    string
+		Journal         *journalView
+		EffortRatings   []*journalRating
+		FeelRatings     []*journalRating
+	}{
+		Activity:       newActivityRow(&detail.Activity),
+		StravaUrl:      fmt.Sprintf("https://www.strava.com/activities/%d", activityId),
+		Units:          units,
+		UnitsToggleUrl: r.URL.Path + "?" + qs.Encode(),
+		ChartWidth:     chartWidth,
+		ChartHeight:    chartHeight,
+	}
+
+	args.Activity.Journal = newJournalView(entry, func(tag string) string {
+		return fmt.Sprintf("/running/?year=%d&type=%s&tag=%s", detail.StartTime().Year(), url.QueryEscape(detail.Type),
+			url.QueryEscape(tag))
+	})
+	args.Journal = args.Activity.Journal
+	if args.Journal == nil {
+		args.Journal = &journalView{}
+	}
+	args.EffortRatings, args.FeelRatings = journalRatings()
+
+	formatHeartrate := func(hr float64) string {
+		if hr <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f", hr)
+	}
+
+	for _, split := range splits {
+		args.Splits = append(args.Splits, &row{
+			Name:      strconv.Itoa(split.Split),
+			Distance:  fmt.Sprintf("%.2f", split.DistanceMeters/unitMeters),
+			Time:      formatSeconds(split.MovingTime),
+			Pace:      formatPace(split.AverageSpeed, unitMeters),
+			Elevation: fmt.Sprintf("%+.0f ft", 3.28084*split.ElevationDifference),
+			Heartrate: formatHeartrate(split.AverageHeartrate),
+		})
+	}
+
+	for _, lap := range detail.Laps {
+		args.Laps = append(args.Laps, &row{
+			Name:      lap.Name,
+			Distance:  fmt.Sprintf("%.2f", lap.DistanceMeters/unitMeters),
+			Time:      formatSeconds(lap.MovingTime),
+			Pace:      formatPace(lap.AverageSpeed, unitMeters),
+			Elevation: fmt.Sprintf("%.0f ft", 3.28084*lap.TotalElevationGain),
+			Heartrate: formatHeartrate(lap.AverageHeartrate),
+		})
+	}
+
+	if streams.Distance != nil {
+		if streams.VelocitySmooth != nil {
+			speeds := make([]float64, len(streams.VelocitySmooth.Data))
+			for i, v := range streams.VelocitySmooth.Data {
+				if v < minChartSpeed {
+					v = minChartSpeed
+				}
+				speeds[i] = v
+			}
+
+			var minSpeed, maxSpeed float64
+			args.PacePoints, minSpeed, maxSpeed = svgPolyline(streams.Distance.Data, speeds)
+			args.PaceFastest = formatPace(maxSpeed, unitMeters)
+			args.PaceSlowest = formatPace(minSpeed, unitMeters)
+		}
+		if streams.Altitude != nil {
+			var minAlt, maxAlt float64
+			args.ElevationPoints, minAlt, maxAlt = svgPolyline(streams.Distance.Data, streams.Altitude.Data)
+			args.ElevationMin = fmt.Sprintf("%.0f ft", 3.28084*minAlt)
+			args.ElevationMax = fmt.Sprintf("%.0f ft", 3.28084*maxAlt)
+		}
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
+
+// getActivityDetails returns the details and streams for an activity, from the local cache if possible and otherwise
+// from the Strava API (after which they are cached).
+func getActivityDetails(ctx context.Context, username string, activityId int64, refresh bool, db *SqliteDb, account *ApiParams) (*ActivityDetail, *ActivityStreams, error) {
+	var detail ActivityDetail
+	var streams ActivityStreams
+
+	// each athlete has their own copy of the activities they've viewed, fetched with their own token, so that Strava
+	// decides what they're allowed to see
+	cached, err := db.ReadActivityDetails(ctx, username, activityId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read from database: %s", err)
+	}
+
+	if cached != nil && !refresh {
+		if err := json.Unmarshal([]byte(cached.Detail), &detail); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse cached activity: %s", err)
+		}
+		if err := json.Unmarshal([]byte(cached.Streams), &streams); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse cached streams: %s", err)
+		}
+		return &detail, &streams, nil
+	}
+
+	accessToken, err := readAccessToken(ctx, username, db, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	detailBody, err := getActivityJson(account, accessToken, fmt.Sprintf("https://www.strava.com/api/v3/activities/%d", activityId))
+	if err != nil {
+		return nil, nil, err
+	}
+	streamsBody, err := getActivityJson(account, accessToken, fmt.Sprintf("https://www.strava.com/api/v3/activities/%d/streams?keys=distance,altitude,velocity_smooth&key_by_type=true", activityId))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := json.Unmarshal(detailBody, &detail); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse activity: %s", err)
+	}
+	if err := json.Unmarshal(streamsBody, &streams); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse streams: %s", err)
+	}
+
+	arg := storage.InsertActivityDetailsParams{
+		ActivityID:  activityId,
+		Username:    username,
+		Detail:      string(detailBody),
+		Streams:     string(streamsBody),
+		FetchedTime: time.Now(),
+	}
+	if err := db.WriteActivityDetails(ctx, &arg); err != nil {
+		// not fatal, we'll just fetch again next time
+		log.Printf("warning: failed to cache details for activity %d: %s", activityId, err)
+	}
+
+	return &detail, &streams, nil
+}
+
+func getActivityJson(account *ApiParams, accessToken string, urls string) ([]byte, error) {
+	req, err := http.NewRequest("GET", urls, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	rsp, err := account.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get: %s", err)
+	}
+	defer internal.DrainAndClose(rsp.Body)
+
+	if err := internal.CheckResponse(rsp); err != nil {
+		return nil, fmt.Errorf("failed to get: %w", err)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(rsp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse body: %s", err)
+	}
+	return body, nil
+}
+
+// formatPace formats a speed, in meters per second, as a minutes:seconds pace per unitMeters.
+func formatPace(speed, unitMeters float64) string {
+	if speed <= 0 {
+		return "-"
+	}
+	secs := int64(unitMeters/speed + 0.5)
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// svgPolyline scales a series of (x, y) points to fit the profile chart dimensions and returns them formatted as the
+// "points" attribute of an SVG polyline, along with the min and max y values.
+func svgPolyline(xs, ys []float64) (string, float64, float64) {
+	n := len(xs)
+	if len(ys) < n {
+		n = len(ys)
+	}
+	if n == 0 {
+		return "", 0, 0
+	}
+
+	minX, maxX := xs[0], xs[0]
+	minY, maxY := ys[0], ys[0]
+	for i := 0; i < n; i++ {
+		if xs[i] < minX {
+			minX = xs[i]
+		}
+		if xs[i] > maxX {
+			maxX = xs[i]
+		}
+		if ys[i] < minY {
+			minY = ys[i]
+		}
+		if ys[i] > maxY {
+			maxY = ys[i]
+		}
+	}
+
+	spanX, spanY := maxX-minX, maxY-minY
+	if spanX == 0 {
+		spanX = 1
+	}
+	if spanY == 0 {
+		spanY = 1
+	}
+
+	step := 1
+	if n > maxChartPoints {
+		step = (n + maxChartPoints - 1) / maxChartPoints
+	}
+
+	var sb strings.Builder
+	for i := 0; i < n; i += step {
+		x := chartWidth * (xs[i] - minX) / spanX
+		y := chartHeight - chartHeight*(ys[i]-minY)/spanY
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%.1f,%.1f", x, y)
+	}
+	return sb.String(), minY, maxY
+}
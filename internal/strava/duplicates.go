@@ -0,0 +1,164 @@
+package strava
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	// activities whose distances differ by more than this fraction aren't considered duplicates, even if they overlap
+	duplicateDistanceTolerance = 0.1
+)
+
+// duplicateDecisions are the athlete's decisions about possible duplicate activities.
+type duplicateDecisions struct {
+	excluded map[int64]int64   // activities the athlete excluded, and what they are duplicates of
+	resolved map[[2]int64]bool // pairs (lower id first) the athlete already made a decision about
+}
+
+func (db *SqliteDb) ReadDuplicateDecisions(ctx context.Context, username string) (*duplicateDecisions, error) {
+	rows, err := db.query.ListDuplicateDecisions(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := &duplicateDecisions{excluded: make(map[int64]int64), resolved: make(map[[2]int64]bool)}
+	for _, row := range rows {
+		if row.Excluded {
+			decisions.excluded[row.ActivityID] = row.OtherID
+		}
+		decisions.resolved[duplicatePairKey(row.ActivityID, row.OtherID)] = true
+	}
+	return decisions, nil
+}
+
+// Excluded returns whether the athlete excluded the activity from their totals, and if so, which activity it
+// duplicates.
+func (d *duplicateDecisions) Excluded(activityId int64) (int64, bool) {
+	other, ok := d.excluded[activityId]
+	return other, ok
+}
+
+// Resolved returns whether the athlete already decided whether the two activities are duplicates.
+func (d *duplicateDecisions) Resolved(a, b int64) bool {
+	return d.resolved[duplicatePairKey(a, b)]
+}
+
+// Counted returns the activities that aren't excluded as duplicates.
+func (d *duplicateDecisions) Counted(activities []Activity) []Activity {
+	var counted []Activity
+	for _, activity := range activities {
+		if _, ok := d.excluded[activity.Id]; !ok {
+			counted = append(counted, activity)
+		}
+	}
+	return counted
+}
+
+func duplicatePairKey(a, b int64) [2]int64 {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int64{a, b}
+}
+
+// findDuplicates returns likely duplicates, as a map from each activity to the other activity it likely duplicates.
+// Activities are likely duplicates if they are the same type, overlap in time, and are about the same distance.
+// Pairs that the athlete already made a decision about are skipped.
+func findDuplicates(activities []Activity, decisions *duplicateDecisions) map[int64]*Activity {
+	sorted := make([]*Activity, len(activities))
+	for i := range activities {
+		sorted[i] = &activities[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].StartTime().Before(sorted[j].StartTime())
+	})
+
+	duplicates := make(map[int64]*Activity)
+	for i, a := range sorted {
+		end := a.StartTime().Add(time.Duration(a.ElapsedTime) * time.Second)
+		for _, b := range sorted[i+1:] {
+			if !b.StartTime().Before(end) {
+				break // no later activities can overlap a
+			}
+			if a.Type != b.Type || decisions.Resolved(a.Id, b.Id) {
+				continue
+			}
+
+			longer := a.DistanceMeters
+			if b.DistanceMeters > longer {
+				longer = b.DistanceMeters
+			}
+			diff := a.DistanceMeters - b.DistanceMeters
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff <= duplicateDistanceTolerance*longer {
+				duplicates[a.Id] = b
+				duplicates[b.Id] = a
+			}
+		}
+	}
+	return duplicates
+}
+
+// DuplicatesHandler handles form posts that record the athlete's decision about a possible duplicate activity, then
+// redirects back to the running page.  The "exclude" action excludes an activity from totals as a duplicate of
+// another, "keep" records that two activities aren't duplicates after all, and "include" undoes an exclusion.
+func DuplicatesHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid activity id %q", r.PostFormValue("id"))
+		return
+	}
+	other, err := strconv.ParseInt(r.PostFormValue("other"), 10, 64)
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid activity id %q", r.PostFormValue("other"))
+		return
+	}
+
+	switch action := r.PostFormValue("action"); action {
+	case "exclude", "keep":
+		arg := storage.UpsertDuplicateDecisionParams{
+			Username:    username,
+			ActivityID:  id,
+			OtherID:     other,
+			Excluded:    action == "exclude",
+			UpdatedTime: time.Now(),
+		}
+		if err := db.query.UpsertDuplicateDecision(r.Context(), arg); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to write decision: %s", err)
+			return
+		}
+	case "include":
+		arg := storage.DeleteDuplicateDecisionParams{Username: username, ActivityID: id, OtherID: other}
+		if err := db.query.DeleteDuplicateDecision(r.Context(), arg); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to delete decision: %s", err)
+			return
+		}
+	default:
+		internal.HttpError(w, http.StatusBadRequest, "unknown action %q", action)
+		return
+	}
+
+	// excluding (or including) an activity changes the athlete's totals, and so maybe their achievements
+	db.HistoryChanged(r.Context(), username)
+
+	http.Redirect(w, r, "/running/", http.StatusSeeOther)
+}
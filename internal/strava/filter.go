@@ -0,0 +1,277 @@
+package strava
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	sortByDate     = "date"
+	sortByDistance = "distance"
+	sortByPace     = "pace"
+
+	// number of activities listed on each page of the running page
+	activitiesPerPage = 50
+
+	// sport type filter value that matches every type of activity
+	anyActivityType = "all"
+)
+
+// filterParams are the query params that make up an activityFilter.
+var filterParams = []string{"from", "to", "min", "max", "q", "tag", "type", "races", "sort", "page"}
+
+// activityFilter narrows and orders the activity list on the running page.  It is parsed from, and encoded back to,
+// query params so that the filter survives in the URL.  Invalid params are ignored, like the year and goal params.
+type activityFilter struct {
+	From, To  time.Time // either may be zero; To is exclusive
+	MinMiles  float64
+	MaxMiles  float64 // zero means no maximum
+	Name      string  // matches activity names, and journal notes, tags and injuries
+	Tag       string  // journal tag
+	Type      string  // Strava sport type, or anyActivityType
+	RacesOnly bool
+	Sort      string
+	Page      int
+}
+
+func parseActivityFilter(qs url.Values) *activityFilter {
+	f := &activityFilter{
+		Name:      strings.TrimSpace(qs.Get("q")),
+		Tag:       strings.ToLower(strings.TrimSpace(qs.Get("tag"))),
+		Type:      "Run",
+		RacesOnly: qs.Get("races") == "1",
+		Sort:      sortByDate,
+		Page:      1,
+	}
+
+	if t, err := time.Parse("2006-01-02", qs.Get("from")); err == nil {
+		f.From = t
+	}
+	if t, err := time.Parse("2006-01-02", qs.Get("to")); err == nil {
+		f.To = t.AddDate(0, 0, 1) // the "to" date is inclusive
+	}
+	if x, err := strconv.ParseFloat(qs.Get("min"), 64); err == nil && x > 0 {
+		f.MinMiles = x
+	}
+	if x, err := strconv.ParseFloat(qs.Get("max"), 64); err == nil && x > 0 {
+		f.MaxMiles = x
+	}
+	if s := qs.Get("type"); s != "" {
+		f.Type = s
+	}
+	switch s := qs.Get("sort"); s {
+	case sortByDistance, sortByPace:
+		f.Sort = s
+	}
+	if i, err := strconv.Atoi(qs.Get("page")); err == nil && i > 0 {
+		f.Page = i
+	}
+	return f
+}
+
+// Filtered returns whether the filter narrows the list beyond the default of all runs.
+func (f *activityFilter) Filtered() bool {
+	return !f.From.IsZero() || !f.To.IsZero() || f.MinMiles > 0 || f.MaxMiles > 0 || f.Name != "" || f.Tag != "" ||
+		f.Type != "Run" || f.RacesOnly
+}
+
+// Match returns whether the activity, along with the athlete's journal entry for it (if any), matches the filter.
+func (f *activityFilter) Match(activity *Activity, entry *storage.StravaJournalEntry) bool {
+	if f.Type != anyActivityType && activity.Type != f.Type {
+		return false
+	}
+	if f.RacesOnly && !activity.IsRace() {
+		return false
+	}
+
+	start := activity.StartTime()
+	if !f.From.IsZero() && start.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !start.Before(f.To) {
+		return false
+	}
+
+	miles := activity.Miles()
+	if miles < f.MinMiles || (f.MaxMiles > 0 && miles > f.MaxMiles) {
+		return false
+	}
+
+	if f.Tag != "" && !journalHasTag(entry, f.Tag) {
+		return false
+	}
+
+	return f.Name == "" || strings.Contains(strings.ToLower(activity.Name), strings.ToLower(f.Name)) ||
+		journalMatches(entry, f.Name)
+}
+
+// SortActivities orders activities newest, longest or fastest first.
+func (f *activityFilter) SortActivities(activities []*Activity) {
+	sort.SliceStable(activities, func(i, j int) bool {
+		a, b := activities[i], activities[j]
+		switch f.Sort {
+		case sortByDistance:
+			return a.DistanceMeters > b.DistanceMeters
+		case sortByPace:
+			return secondsPerMeter(a) < secondsPerMeter(b)
+		default:
+			return a.StartDate > b.StartDate
+		}
+	})
+}
+
+// secondsPerMeter returns the activity's pace, treating activities without any distance as infinitely slow.
+func secondsPerMeter(activity *Activity) float64 {
+	if activity.DistanceMeters <= 0 {
+		return float64(1 << 62)
+	}
+	return activity.MovingTime / activity.DistanceMeters
+}
+
+// Values encodes the filter as query params, omitting any that are at their defaults.
+func (f *activityFilter) Values() url.Values {
+	qs := make(url.Values)
+	if !f.From.IsZero() {
+		qs.Set("from", f.From.Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		qs.Set("to", f.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	if f.MinMiles > 0 {
+		qs.Set("min", strconv.FormatFloat(f.MinMiles, 'f', -1, 64))
+	}
+	if f.MaxMiles > 0 {
+		qs.Set("max", strconv.FormatFloat(f.MaxMiles, 'f', -1, 64))
+	}
+	if f.Name != "" {
+		qs.Set("q", f.Name)
+	}
+	if f.Tag != "" {
+		qs.Set("tag", f.Tag)
+	}
+	if f.Type != "Run" {
+		qs.Set("type", f.Type)
+	}
+	if f.RacesOnly {
+		qs.Set("races", "1")
+	}
+	if f.Sort != sortByDate {
+		qs.Set("sort", f.Sort)
+	}
+	if f.Page > 1 {
+		qs.Set("page", strconv.Itoa(f.Page))
+	}
+	return qs
+}
+
+// Url returns the running page URL for this filter, keeping any other query params (like year) from the request.
+func (f *activityFilter) Url(r *http.Request) string {
+	qs := r.URL.Query()
+	for _, key := range filterParams {
+		qs.Del(key)
+	}
+	for key, vals := range f.Values() {
+		qs[key] = vals
+	}
+	return r.URL.Path + "?" + qs.Encode()
+}
+
+// filterView holds the current filter values, as shown in the filter form on the running page.
+type filterView struct {
+	From, To  string
+	Min, Max  string
+	Name      string
+	Tag       string
+	Type      string
+	Types     []string // sport types to choose from
+	Sort      string
+	Filtered  bool
+	ClearUrl  string
+	RacesUrl  string // toggles the races-only filter
+	RacesOnly bool
+	PrevUrl   string
+	NextUrl   string
+	Page      int
+	PageCount int
+	ListStart int               // number of the first activity on the page
+	Hidden    map[string]string // other query params to keep when the filter form is submitted
+}
+
+// Paginate returns the activities on the filter's page, and fills in the view's paging fields.
+func (v *filterView) Paginate(r *http.Request, f *activityFilter, activities []*Activity) []*Activity {
+	v.PageCount = (len(activities) + activitiesPerPage - 1) / activitiesPerPage
+	v.Page = f.Page
+	if v.Page > v.PageCount {
+		v.Page = v.PageCount
+	}
+	if v.Page < 1 {
+		v.Page = 1
+	}
+
+	page := *f
+	if v.Page > 1 {
+		page.Page = v.Page - 1
+		v.PrevUrl = page.Url(r)
+	}
+	if v.Page < v.PageCount {
+		page.Page = v.Page + 1
+		v.NextUrl = page.Url(r)
+	}
+
+	lo := (v.Page - 1) * activitiesPerPage
+	hi := lo + activitiesPerPage
+	if hi > len(activities) {
+		hi = len(activities)
+	}
+	v.ListStart = lo + 1
+	return activities[lo:hi]
+}
+
+func newFilterView(r *http.Request, f *activityFilter, types []string) *filterView {
+	v := &filterView{
+		Min:       f.Values().Get("min"),
+		Max:       f.Values().Get("max"),
+		Name:      f.Name,
+		Tag:       f.Tag,
+		Type:      f.Type,
+		Types:     types,
+		Sort:      f.Sort,
+		Filtered:  f.Filtered(),
+		ClearUrl:  (&activityFilter{Type: "Run", Sort: sortByDate, Page: 1}).Url(r),
+		RacesOnly: f.RacesOnly,
+		Hidden:    make(map[string]string),
+	}
+	if !f.From.IsZero() {
+		v.From = f.From.Format("2006-01-02")
+	}
+	if !f.To.IsZero() {
+		v.To = f.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+
+	races := *f
+	races.RacesOnly = !f.RacesOnly
+	races.Page = 1
+	v.RacesUrl = races.Url(r)
+
+	for key := range r.URL.Query() {
+		if !contains(filterParams, key) {
+			v.Hidden[key] = r.URL.Query().Get(key)
+		}
+	}
+	return v
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
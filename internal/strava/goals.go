@@ -0,0 +1,255 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	GoalDistance  = "distance"
+	GoalTime      = "time"
+	GoalElevation = "elevation"
+	GoalRuns      = "runs"
+	GoalDays      = "days"
+)
+
+type goalKind struct {
+	Kind  string
+	Label string
+	Unit  string
+}
+
+// goalKinds are the supported types of yearly goal, in display order.
+var goalKinds = []goalKind{
+	{GoalDistance, "Distance", "miles"},
+	{GoalTime, "Moving time", "hours"},
+	{GoalElevation, "Elevation gain", "feet"},
+	{GoalRuns, "Runs", "runs"},
+	{GoalDays, "Active days", "days"},
+}
+
+// ListGoals returns the athlete's goals for the given year, in display order.
+func (db *SqliteDb) ListGoals(ctx context.Context, username string, year int) ([]storage.StravaGoal, error) {
+	rows, err := db.query.ListGoals(ctx, storage.ListGoalsParams{Username: username, Year: int64(year)})
+	if err != nil {
+		return nil, err
+	}
+
+	var goals []storage.StravaGoal
+	for _, k := range goalKinds {
+		for _, row := range rows {
+			if row.Kind == k.Kind {
+				goals = append(goals, row)
+			}
+		}
+	}
+	return goals, nil
+}
+
+// WriteGoals replaces the athlete's goals for the given year with the given targets, keyed by goal kind.
+func (db *SqliteDb) WriteGoals(ctx context.Context, username string, year int, targets map[string]float64) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := db.query.WithTx(tx)
+	if err := query.DeleteGoals(ctx, storage.DeleteGoalsParams{Username: username, Year: int64(year)}); err != nil {
+		return fmt.Errorf("failed to delete goals: %w", err)
+	}
+
+	now := time.Now()
+	for kind, target := range targets {
+		err := query.UpsertGoal(ctx, storage.UpsertGoalParams{
+			Username:    username,
+			Year:        int64(year),
+			Kind:        kind,
+			Target:      target,
+			UpdatedTime: now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s goal: %w", kind, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// yearGoals returns the athlete's goals for the given year, falling back to the default mileage goal if they haven't
+// set any.
+func yearGoals(ctx context.Context, username string, year int, db *SqliteDb) ([]storage.StravaGoal, error) {
+	goals, err := db.ListGoals(ctx, username, year)
+	if err != nil {
+		return nil, err
+	}
+	if len(goals) == 0 {
+		goals = append(goals, storage.StravaGoal{
+			Username: username,
+			Year:     int64(year),
+			Kind:     GoalDistance,
+			Target:   float64(defaultGoal(year)),
+		})
+	}
+	return goals, nil
+}
+
+// goalTotals sums the given runs towards each kind of goal.
+func goalTotals(activities []Activity) map[string]float64 {
+	totals := make(map[string]float64)
+	days := make(map[string]bool)
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type != "Run" {
+			continue
+		}
+
+		totals[GoalDistance] += activity.Miles()
+		totals[GoalTime] += activity.MovingTime / 3600
+		totals[GoalElevation] += activity.ElevationFeet()
+		totals[GoalRuns]++
+		days[activity.StartTime().Format("2006-01-02")] = true
+	}
+	totals[GoalDays] = float64(len(days))
+	return totals
+}
+
+// goalView is a single goal's progress gauge on the running page.
+type goalView struct {
+	Kind        string
+	Label       string
+	Unit        string
+	Actual      string
+	Target      string
+	Scaled      string
+	Progress    string
+	GaugeRotate int
+}
+
+// newGoalViews compares each goal, pro-rated by the given fraction of the year, with the athlete's totals.
+func newGoalViews(goals []storage.StravaGoal, totals map[string]float64, fraction float64) []*goalView {
+	var views []*goalView
+	for _, goal := range goals {
+		kind := lookupGoalKind(goal.Kind)
+		if kind == nil {
+			continue
+		}
+
+		scaled := goal.Target * fraction
+		progress := 100 * totals[goal.Kind] / scaled
+		views = append(views, &goalView{
+			Kind:        kind.Kind,
+			Label:       kind.Label,
+			Unit:        kind.Unit,
+			Actual:      formatGoalAmount(goal.Kind, totals[goal.Kind]),
+			Target:      strconv.FormatFloat(goal.Target, 'f', -1, 64),
+			Scaled:      formatGoalAmount(goal.Kind, scaled),
+			Progress:    fmt.Sprintf("%.0f", progress),
+			GaugeRotate: int(90.0 * progress / 100),
+		})
+	}
+	return views
+}
+
+func lookupGoalKind(kind string) *goalKind {
+	for i := range goalKinds {
+		if goalKinds[i].Kind == kind {
+			return &goalKinds[i]
+		}
+	}
+	return nil
+}
+
+// formatGoalAmount formats an amount of progress towards a goal, which is whole for counts and elevation, but
+// otherwise has a decimal place.
+func formatGoalAmount(kind string, amount float64) string {
+	switch kind {
+	case GoalDistance, GoalTime:
+		return fmt.Sprintf("%.1f", amount)
+	default:
+		return fmt.Sprintf("%.0f", amount)
+	}
+}
+
+// goalSetting is one of the inputs in the goal settings form.
+type goalSetting struct {
+	Kind   string
+	Label  string
+	Unit   string
+	Target string
+}
+
+func newGoalSettings(goals []storage.StravaGoal) []*goalSetting {
+	var settings []*goalSetting
+	for _, k := range goalKinds {
+		setting := &goalSetting{Kind: k.Kind, Label: k.Label, Unit: k.Unit}
+		for _, goal := range goals {
+			if goal.Kind == k.Kind {
+				setting.Target = strconv.FormatFloat(goal.Target, 'f', -1, 64)
+			}
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+// GoalsHandler handles form posts that set the athlete's goals for a year, and how they are scheduled over the year,
+// then redirects back to the running page.  Any combination of goal types may be set; blank targets remove that goal.
+func GoalsHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	year, err := strconv.Atoi(r.PostFormValue("year"))
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid year %q", r.PostFormValue("year"))
+		return
+	}
+
+	targets := make(map[string]float64)
+	for _, k := range goalKinds {
+		s := strings.TrimSpace(r.PostFormValue(k.Kind))
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f <= 0 {
+			internal.HttpError(w, http.StatusBadRequest, "invalid %s goal %q", strings.ToLower(k.Label), s)
+			return
+		}
+		targets[k.Kind] = f
+	}
+
+	schedule := &goalSchedule{Year: year, Period: r.PostFormValue("schedule_period")}
+	if schedule.Period != "" {
+		schedule.Weights, err = parseScheduleWeights(schedule.Period, r.PostFormValue("schedule"))
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid goal schedule: %s", err)
+			return
+		}
+	}
+
+	if err := db.WriteGoals(r.Context(), username, year, targets); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write goals: %s", err)
+		return
+	}
+	if err := db.WriteGoalSchedule(r.Context(), username, schedule); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write goal schedule: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/running/?year=%d", year), http.StatusSeeOther)
+}
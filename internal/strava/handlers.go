@@ -5,15 +5,16 @@ import (
 	"html/template"
 	"log"
 	"net/http"
-	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ianrose14/website/internal"
 	"github.com/ianrose14/website/internal/storage"
 )
 
-func Handler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db KVDB, account *ApiParams) {
+func Handler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
 	year := time.Now().Year()
 	if s := r.URL.Query().Get("year"); s != "" {
 		if i, err := strconv.Atoi(s); err == nil {
@@ -21,114 +22,305 @@ func Handler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db
 		}
 	}
 
-	goalMiles := defaultGoalMiles[year]
-	if goalMiles == 0 {
-		goalMiles = 500
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
 	}
-	if s := r.URL.Query().Get("goal"); s != "" {
-		if i, err := strconv.Atoi(s); err == nil {
-			goalMiles = i
+
+	// live updates only re-render the parts of the page that depend on activities, from those already stored, since
+	// they are sent once the activities have arrived (see LiveHandler)
+	liveUpdate := r.Header.Get(liveUpdateHeader) != ""
+
+	if !liveUpdate {
+		accessToken, err := readAccessToken(r.Context(), username, db, account)
+		if err != nil {
+			if err == ErrNeedsAuth {
+				http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+				return
+			}
+
+			http.Error(w, fmt.Sprintf("failed to read access token: %s", err), http.StatusInternalServerError)
+			return
 		}
-	}
 
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		c, err := r.Cookie("username")
-		if err == nil {
-			username = c.Value
+		if _, err := getProfile(account, accessToken); err != nil {
+			http.Error(w, fmt.Sprintf("failed to get profile info: %s", err), http.StatusInternalServerError)
+			return
 		}
 	}
 
-	if username == "" {
-		http.Redirect(w, r, getAuthUrl(account), http.StatusTemporaryRedirect)
+	now := time.Now()
+	queryStart, queryEnd, yearFraction := yearBounds(year, now)
+
+	goals, err := yearGoals(r.Context(), username, year, db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read goals: %s", err), http.StatusInternalServerError)
 		return
 	}
+	settings := newGoalSettings(goals)
 
-	accessToken, err := readAccessToken(r.Context(), username, db, account)
+	schedule, err := db.ReadGoalSchedule(r.Context(), username, year)
 	if err != nil {
-		if err == ErrNeedsAuth {
-			http.Redirect(w, r, getAuthUrl(account), http.StatusTemporaryRedirect)
-			return
+		http.Error(w, fmt.Sprintf("failed to read goal schedule: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if schedule.Period != "" {
+		yearFraction = schedule.Fraction(queryStart, queryEnd)
+	}
+
+	// a mileage goal in the query string overrides the athlete's goals, but isn't saved
+	if s := r.URL.Query().Get("goal"); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			goals = []storage.StravaGoal{{Username: username, Year: int64(year), Kind: GoalDistance, Target: float64(i)}}
 		}
+	}
 
-		http.Error(w, fmt.Sprintf("failed to read access token: %s", err), http.StatusInternalServerError)
+	races, err := db.ListRaces(r.Context(), username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read races: %s", err), http.StatusInternalServerError)
 		return
 	}
 
-	profile, err := getProfile(accessToken)
+	shareSettings, err := db.ReadShareSettings(r.Context(), username)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get profile info: %s", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to read share settings: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// when looking at the current year, also fetch enough history to compare the last two training blocks
+	fetchStart := queryStart
+	if now.Year() == year {
+		if t := now.AddDate(0, 0, -2*trainingBlockDays); t.Before(fetchStart) {
+			fetchStart = t
+		}
+	}
+
+	var recent []Activity
+	if liveUpdate {
+		recent, err = db.LoadActivities(r.Context(), username, fetchStart, queryEnd)
+	} else {
+		recent, err = syncActivities(r.Context(), username, fetchStart, queryEnd, db, account)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read activities: %s", err), http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "username",
-		Value:   username,
-		Expires: time.Now().Add(7 * 24 * time.Hour),
-	})
+	var activities []Activity
+	for _, activity := range recent {
+		if !activity.StartTime().Before(queryStart) {
+			activities = append(activities, activity)
+		}
+	}
 
-	now := time.Now()
-	queryStart := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	decisions, err := db.ReadDuplicateDecisions(r.Context(), username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read duplicate decisions: %s", err), http.StatusInternalServerError)
+		return
+	}
+	duplicates := findDuplicates(activities, decisions)
 
-	var scaledGoalMiles float64
-	var queryEnd time.Time
+	plan, err := db.ReadPlan(r.Context(), username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read training plan: %s", err), http.StatusInternalServerError)
+		return
+	}
 
-	if now.Year() == year {
-		scaledGoalMiles = float64(goalMiles) * float64(now.YearDay()) / 365
-		queryEnd = queryStart.AddDate(0, 0, now.YearDay()) // finish is intentionally midnight at the END of the day
-	} else {
-		scaledGoalMiles = float64(goalMiles)
-		queryEnd = time.Date(year+1, time.January, 1, 0, 0, 0, 0, now.Location()) // Midnight, start of new years day
+	journal, err := db.ReadJournal(r.Context(), username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read journal: %s", err), http.StatusInternalServerError)
+		return
 	}
 
-	activities, err := doStravaQuery(r.Context(), username, queryStart, queryEnd, db, account)
+	challenges, err := db.query.ListChallengesByMember(r.Context(), username)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to query strava: %s", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to read challenges: %s", err), http.StatusInternalServerError)
 		return
 	}
 
+	filter := parseActivityFilter(r.URL.Query())
+
 	args := struct {
 		Username        string
-		Activities      []string
+		Activities      []*activityRow
+		RunCount        int
 		MilesTotal      string
-		MilesYearGoal   int
-		MilesScaledGoal string
-		Progress        string
-		GaugeRotate     int
+		ElevationTotal  string
+		TimeOnFeet      string
+		Year            int
+		Goals           []*goalView
+		GoalSettings    []*goalSetting
+		Schedule        *scheduleSetting
+		Filter          *filterView
+		Countdown       *raceCountdown
+		Races           []*raceView
+		Plan            *planView
+		Challenges      []*challengeLink
+		ChallengeSports []string
+		Share           *shareView
+		Achievements    []*achievementView
 	}{
-		Username:        profile.Username,
-		MilesYearGoal:   goalMiles,
-		MilesScaledGoal: fmt.Sprintf("%.1f", scaledGoalMiles),
+		Username:        username,
+		Year:            year,
+		GoalSettings:    settings,
+		Schedule:        newScheduleSetting(schedule),
+		Races:           upcomingRaces(races, now),
+		Plan:            newPlanView(plan, decisions.Counted(activities), year, now),
+		Challenges:      newChallengeLinks(challenges, now),
+		ChallengeSports: challengeSports,
+		Share:           newShareView(shareSettings),
+	}
+
+	if now.Year() == year {
+		args.Countdown = newRaceCountdown(races, recent, now)
+	}
+
+	// achievements are read after syncing, so that any earned by just-arrived activities are included
+	achievements, err := db.ListAchievements(r.Context(), username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read achievements: %s", err), http.StatusInternalServerError)
+		return
 	}
+	args.Achievements = newAchievementViews(achievements)
 
-	var sumMiles float64
-	for _, activity := range activities {
-		if activity.Type != "Run" {
+	var matched []*Activity
+	var types []string
+	var sumMiles, sumElevation, sumSeconds float64
+	for i := range activities {
+		activity := &activities[i]
+		if !contains(types, activity.Type) {
+			types = append(types, activity.Type)
+		}
+		if !filter.Match(activity, journal[activity.Id]) {
 			continue
 		}
-		secondsPerMile := int64(activity.MovingTime/activity.Miles() + 0.5000001)
-		args.Activities = append(args.Activities,
-			fmt.Sprintf("%s: %.1fK (%.1f miles) in %s (%d:%02d pace) on %s", activity.Name,
-				activity.DistanceMeters/1000., activity.Miles(),
-				formatSeconds(activity.MovingTime), secondsPerMile/60, secondsPerMile%60,
-				activity.StartDate))
 
+		matched = append(matched, activity)
+		if _, excluded := decisions.Excluded(activity.Id); excluded {
+			continue
+		}
 		sumMiles += activity.Miles()
+		sumElevation += activity.ElevationFeet()
+		sumSeconds += activity.MovingTime
 	}
+	sort.Strings(types)
+	filter.SortActivities(matched)
 
-	progress := 100 * sumMiles / scaledGoalMiles
-
+	args.RunCount = len(matched)
 	args.MilesTotal = fmt.Sprintf("%.1f", sumMiles)
-	args.Progress = fmt.Sprintf("%.0f", progress)
-	args.GaugeRotate = int(90.0 * progress / 100)
+	args.ElevationTotal = fmt.Sprintf("%.0f", sumElevation)
+	args.TimeOnFeet = formatHours(sumSeconds)
+	args.Goals = newGoalViews(goals, goalTotals(decisions.Counted(activities)), yearFraction)
+
+	args.Filter = newFilterView(r, filter, types)
+	tagUrl := func(tag string) string {
+		tagged := &activityFilter{Tag: tag, Type: filter.Type, Sort: sortByDate, Page: 1}
+		return tagged.Url(r)
+	}
+	for _, activity := range args.Filter.Paginate(r, filter, matched) {
+		row := newActivityRow(activity)
+		row.Journal = newJournalView(journal[activity.Id], tagUrl)
+		if other, ok := decisions.Excluded(activity.Id); ok {
+			row.ExcludedAsDuplicateOf = other
+		} else if other := duplicates[activity.Id]; other != nil {
+			row.PossibleDuplicate = newActivityRow(other)
+		}
+		args.Activities = append(args.Activities, row)
+	}
 
-	if err := tmpl.Execute(w, &args); err != nil {
+	if liveUpdate {
+		w.Header().Set("Cache-Control", "no-store")
+		err = tmpl.ExecuteTemplate(w, "live", &args)
+	} else {
+		err = tmpl.Execute(w, &args)
+	}
+	if err != nil {
 		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
 		return
 	}
 }
 
-func TokenHandler(w http.ResponseWriter, r *http.Request, db KVDB, account *ApiParams) {
+// defaultGoal returns the mileage goal for the given year, when none is specified.
+func defaultGoal(year int) int {
+	if goalMiles := defaultGoalMiles[year]; goalMiles != 0 {
+		return goalMiles
+	}
+	return 500
+}
+
+// yearBounds returns the time range to query for activities in the given year, along with the fraction of the year
+// that has elapsed as of now, for scaling goals.
+func yearBounds(year int, now time.Time) (time.Time, time.Time, float64) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if now.Year() == year {
+		end := start.AddDate(0, 0, now.YearDay()) // finish is intentionally midnight at the END of the day
+		return start, end, float64(now.YearDay()) / 365
+	}
+
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, now.Location()) // Midnight, start of new years day
+	return start, end, 1
+}
+
+// activityRow is a single entry in the activity list on the running page.
+type activityRow struct {
+	Id      int64
+	Url     string
+	Summary string
+	Metrics string
+	Label   string
+
+	// set if the athlete excluded this activity from their totals as a duplicate of another
+	ExcludedAsDuplicateOf int64
+
+	// set if this activity looks like a duplicate of another, which the athlete hasn't made a decision about yet
+	PossibleDuplicate *activityRow
+
+	// the athlete's private journal entry for this activity, if any (never set on shared pages)
+	Journal *journalView
+}
+
+func newActivityRow(activity *Activity) *activityRow {
+	secondsPerMile := int64(activity.MovingTime/activity.Miles() + 0.5000001)
+	row := &activityRow{
+		Id:  activity.Id,
+		Url: fmt.Sprintf("/running/activity/%d", activity.Id),
+		Summary: fmt.Sprintf("%s: %.1fK (%.1f miles) in %s (%d:%02d pace)", activity.Name,
+			activity.DistanceMeters/1000., activity.Miles(),
+			formatSeconds(activity.MovingTime), secondsPerMile/60, secondsPerMile%60),
+	}
+	if activity.StartDate != "" {
+		row.Summary += " on " + activity.StartDate
+	}
+
+	var metrics []string
+	if activity.ElapsedTime > 0 {
+		metrics = append(metrics, fmt.Sprintf("%s elapsed", formatSeconds(activity.ElapsedTime)))
+	}
+	if activity.TotalElevationGain > 0 {
+		metrics = append(metrics, fmt.Sprintf("%.0f ft gain", activity.ElevationFeet()))
+	}
+	if activity.AverageHeartrate > 0 {
+		metrics = append(metrics, fmt.Sprintf("HR %.0f avg / %.0f max", activity.AverageHeartrate, activity.MaxHeartrate))
+	}
+	if activity.AverageCadence > 0 {
+		metrics = append(metrics, fmt.Sprintf("%.0f spm", activity.StepsPerMinute()))
+	}
+	if activity.SufferScore > 0 {
+		metrics = append(metrics, fmt.Sprintf("suffer score %.0f", activity.SufferScore))
+	}
+	row.Metrics = strings.Join(metrics, ", ")
+
+	switch {
+	case activity.IsRace():
+		row.Label = "race"
+	case activity.IsLongRun():
+		row.Label = "long run"
+	}
+
+	return row
+}
+
+func TokenHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		return
@@ -147,7 +339,7 @@ func TokenHandler(w http.ResponseWriter, r *http.Request, db KVDB, account *ApiP
 		ExpiresAt:    time.Unix(rsp.ExpiresAt, 0),
 	}
 
-	profile, err := getProfile(rsp.AccessToken)
+	profile, err := getProfile(account, rsp.AccessToken)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to get profile info: %s", err), http.StatusInternalServerError)
 		return
@@ -160,10 +352,22 @@ func TokenHandler(w http.ResponseWriter, r *http.Request, db KVDB, account *ApiP
 		return
 	}
 
-	qs := make(url.Values)
-	qs.Set("username", profile.Username)
-	qs.Set("year", strconv.Itoa(time.Now().Year()))
-	urlStr := "/running/?" + qs.Encode()
+	if err := startSession(w, r, profile.Username, db); err != nil {
+		http.Error(w, fmt.Sprintf("failed to start session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	urlStr := signedInUrl(r.URL.Query().Get("state"), time.Now())
 	log.Printf("successful token exchange, redirecting to %s", urlStr)
 	http.Redirect(w, r, urlStr, http.StatusTemporaryRedirect)
 }
+
+// signedInUrl returns where to send an athlete once they have signed in: the return path from the OAuth state param if
+// there is a valid one, and otherwise the running page for the current year.  The session cookie says who they are, so
+// the url doesn't need to.
+func signedInUrl(state string, now time.Time) string {
+	if isReturnPath(state) {
+		return state
+	}
+	return "/running/?year=" + strconv.Itoa(now.Year())
+}
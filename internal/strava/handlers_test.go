@@ -0,0 +1,28 @@
+package strava
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignedInUrl(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{"", "/running/?year=2024"},
+		{"/running/vdot/", "/running/vdot/"},
+		{"/running/log/?period=month&date=2024-03-01", "/running/log/?period=month&date=2024-03-01"},
+		{"/running/?year=2022", "/running/?year=2022"},
+		{"https://evil.example.com/running/", "/running/?year=2024"},
+		{"//evil.example.com/running/", "/running/?year=2024"},
+		{"/albums/", "/running/?year=2024"},
+	}
+
+	for _, tt := range tests {
+		if got := signedInUrl(tt.state, now); got != tt.want {
+			t.Errorf("signedInUrl(%q) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
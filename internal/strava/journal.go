@@ -0,0 +1,193 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	// ratings are from 1 up to these; zero means unrated
+	maxEffortRating = 10
+	maxFeelRating   = 5
+)
+
+// feelLabels describe each "how did it feel" rating.
+var feelLabels = []string{"", "awful", "rough", "ok", "good", "great"}
+
+// ReadJournal returns all of the athlete's journal entries, keyed by activity id.  Journal entries are private notes
+// that only ever live in the local database, and are never sent to Strava.
+func (db *SqliteDb) ReadJournal(ctx context.Context, username string) (map[int64]*storage.StravaJournalEntry, error) {
+	rows, err := db.query.ListJournalEntries(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[int64]*storage.StravaJournalEntry)
+	for i := range rows {
+		entries[rows[i].ActivityID] = &rows[i]
+	}
+	return entries, nil
+}
+
+// ReadJournalEntry returns the athlete's journal entry for an activity, or nil if there is none.
+func (db *SqliteDb) ReadJournalEntry(ctx context.Context, username string, activityId int64) (*storage.StravaJournalEntry, error) {
+	row, err := db.query.FetchJournalEntry(ctx, storage.FetchJournalEntryParams{Username: username, ActivityID: activityId})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+// parseTags splits a comma-separated list of tags, which are lower-cased and deduplicated.
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// journalMatches returns whether the search text appears in the entry's notes, tags or injury.
+func journalMatches(entry *storage.StravaJournalEntry, q string) bool {
+	if entry == nil {
+		return false
+	}
+	q = strings.ToLower(q)
+	return strings.Contains(strings.ToLower(entry.Notes), q) || strings.Contains(entry.Tags, q) ||
+		strings.Contains(strings.ToLower(entry.Injury), q)
+}
+
+// journalHasTag returns whether the entry is tagged with the given (lower case) tag.
+func journalHasTag(entry *storage.StravaJournalEntry, tag string) bool {
+	return entry != nil && contains(parseTags(entry.Tags), tag)
+}
+
+type journalTag struct {
+	Name string
+	Url  string // lists all runs with this tag
+}
+
+// journalView is a journal entry, as shown in the activity list and on the activity page.
+type journalView struct {
+	Notes     string
+	Effort    int64
+	Feel      int64
+	FeelLabel string
+	Tags      []*journalTag
+	TagsText  string
+	Injury    string
+}
+
+// newJournalView returns the view of a journal entry, or nil if there is no entry.  tagUrl returns the URL that lists
+// all runs with the given tag.
+func newJournalView(entry *storage.StravaJournalEntry, tagUrl func(string) string) *journalView {
+	if entry == nil {
+		return nil
+	}
+
+	v := &journalView{
+		Notes:    entry.Notes,
+		Effort:   entry.Effort,
+		Feel:     entry.Feel,
+		TagsText: entry.Tags,
+		Injury:   entry.Injury,
+	}
+	if entry.Feel > 0 && entry.Feel < int64(len(feelLabels)) {
+		v.FeelLabel = feelLabels[entry.Feel]
+	}
+	for _, tag := range parseTags(entry.Tags) {
+		v.Tags = append(v.Tags, &journalTag{Name: tag, Url: tagUrl(tag)})
+	}
+	return v
+}
+
+// journalRating is an option in one of the journal form's rating menus.
+type journalRating struct {
+	Value int64
+	Label string
+}
+
+func journalRatings() (effort, feel []*journalRating) {
+	for i := int64(1); i <= maxEffortRating; i++ {
+		effort = append(effort, &journalRating{Value: i, Label: strconv.FormatInt(i, 10)})
+	}
+	for i := int64(1); i <= maxFeelRating; i++ {
+		feel = append(feel, &journalRating{Value: i, Label: feelLabels[i]})
+	}
+	return effort, feel
+}
+
+// parseRating parses an optional rating from 1 to max; empty means unrated.
+func parseRating(s string, max int64) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || i < 1 || i > max {
+		return 0, fmt.Errorf("rating must be from 1 to %d", max)
+	}
+	return i, nil
+}
+
+// JournalHandler handles form posts that save the athlete's journal entry for an activity, then redirects back to the
+// activity page.  Saving an empty entry deletes it.
+func JournalHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid activity id %q", r.PostFormValue("id"))
+		return
+	}
+
+	arg := storage.UpsertJournalEntryParams{
+		Username:    username,
+		ActivityID:  id,
+		Notes:       strings.TrimSpace(r.PostFormValue("notes")),
+		Tags:        strings.Join(parseTags(r.PostFormValue("tags")), ", "),
+		Injury:      strings.TrimSpace(r.PostFormValue("injury")),
+		UpdatedTime: time.Now(),
+	}
+	if arg.Effort, err = parseRating(r.PostFormValue("effort"), maxEffortRating); err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid effort: %s", err)
+		return
+	}
+	if arg.Feel, err = parseRating(r.PostFormValue("feel"), maxFeelRating); err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "invalid feel: %s", err)
+		return
+	}
+
+	if arg.Notes == "" && arg.Tags == "" && arg.Injury == "" && arg.Effort == 0 && arg.Feel == 0 {
+		err = db.query.DeleteJournalEntry(r.Context(), storage.DeleteJournalEntryParams{Username: username, ActivityID: id})
+	} else {
+		err = db.query.UpsertJournalEntry(r.Context(), arg)
+	}
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write journal entry: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/running/activity/%d", id), http.StatusSeeOther)
+}
@@ -0,0 +1,165 @@
+package strava
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+const (
+	// liveHeartbeat is how often an idle event stream sends a comment, so that dead connections are noticed.
+	liveHeartbeat = 30 * time.Second
+
+	// liveWriteTimeout bounds each write to an event stream, in place of the server's write timeout.
+	liveWriteTimeout = 10 * time.Second
+
+	// liveUpdateHeader marks the running page's requests for the parts that have changed, once an event says so.
+	liveUpdateHeader = "X-Live-Update"
+)
+
+// LiveUpdates is a small in-process pub/sub, keyed by username, that tells open running pages (over server-sent
+// events) when new activities have arrived, so they can refresh without a reload.
+type LiveUpdates struct {
+	done <-chan struct{}
+	boot string // distinguishes event ids from before a restart
+
+	mu       sync.Mutex
+	versions map[string]int64
+	subs     map[string]map[chan struct{}]bool
+}
+
+// NewLiveUpdates returns an empty LiveUpdates, whose event streams all end once ctx is done.
+func NewLiveUpdates(ctx context.Context) *LiveUpdates {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	return &LiveUpdates{
+		done:     ctx.Done(),
+		boot:     hex.EncodeToString(b),
+		versions: make(map[string]int64),
+		subs:     make(map[string]map[chan struct{}]bool),
+	}
+}
+
+// ActivitiesArrived is an ActivityListener that publishes an update to the athlete's open pages.
+func (l *LiveUpdates) ActivitiesArrived(ctx context.Context, username string, added []Activity) {
+	l.Publish(username)
+}
+
+// Publish tells all of the athlete's open pages that their activities have changed.
+func (l *LiveUpdates) Publish(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.versions[username]++
+	for ch := range l.subs[username] {
+		// subscribers only need to know that something changed, so one pending notification is as good as many
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// eventId returns the id of the athlete's most recent update.
+func (l *LiveUpdates) eventId(username string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprintf("%s-%d", l.boot, l.versions[username])
+}
+
+func (l *LiveUpdates) subscribe(username string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subs[username] == nil {
+		l.subs[username] = make(map[chan struct{}]bool)
+	}
+	l.subs[username][ch] = true
+
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs[username], ch)
+		if len(l.subs[username]) == 0 {
+			delete(l.subs, username)
+		}
+	}
+}
+
+// LiveHandler streams the signed-in athlete's updates as server-sent "activities" events, until the browser goes away
+// or the server shuts down.
+func LiveHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, live *LiveUpdates) {
+	username, err := signedInAthlete(r, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read session: %s", err)
+		return
+	}
+	if username == "" {
+		internal.HttpError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		internal.HttpError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	// a stream stays open far longer than the server's write timeout allows, so each write gets its own deadline
+	// instead.  This goes through the ResponseController rather than the connection, which HTTP/2 shares between
+	// streams; writers that can't take a deadline have no timeout to replace.
+	rc := http.NewResponseController(w)
+	extendDeadline := func() {
+		_ = rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	}
+
+	// subscribe before reading the current event id, so that no update can slip in between
+	updates, cancel := live.subscribe(username)
+	defer cancel()
+
+	extendDeadline()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// an event is sent straight away if the browser is reconnecting and missed an update, but otherwise the current
+	// event id is only recorded, so that it can tell us what it last saw next time
+	id := live.eventId(username)
+	if last := r.Header.Get("Last-Event-ID"); last != "" && last != id {
+		fmt.Fprintf(w, "retry: 1000\nid: %s\nevent: activities\ndata: {}\n\n", id)
+	} else {
+		fmt.Fprintf(w, "retry: 1000\nid: %s\n\n", id)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-updates:
+			extendDeadline()
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: activities\ndata: {}\n\n", live.eventId(username)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			extendDeadline()
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-live.done:
+			return
+		}
+		flusher.Flush()
+	}
+}
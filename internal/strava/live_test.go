@@ -0,0 +1,96 @@
+package strava
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveHandlerOutlivesWriteTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		http2     bool
+		wantMajor int
+	}{
+		{"http/1.1", false, 1},
+		// under HTTP/2 the write timeout is kept for each stream, and the connection is shared with other streams
+		{"http/2", true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			db := newTestDb(t)
+			live := NewLiveUpdates(ctx)
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				LiveHandler(w, r, db, live)
+			}))
+			srv.Config.WriteTimeout = 200 * time.Millisecond
+			srv.EnableHTTP2 = tt.http2
+			srv.StartTLS()
+			defer srv.Close()
+
+			rec := httptest.NewRecorder()
+			if err := startSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), "athlete", db); err != nil {
+				t.Fatalf("failed to start session: %s", err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range rec.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.ProtoMajor != tt.wantMajor {
+				t.Fatalf("got status %d over %s, want 200 over HTTP/%d", resp.StatusCode, resp.Proto, tt.wantMajor)
+			}
+
+			events := make(chan string, 10)
+			go func() {
+				scanner := bufio.NewScanner(resp.Body)
+				for scanner.Scan() {
+					if strings.HasPrefix(scanner.Text(), "event: ") {
+						events <- strings.TrimPrefix(scanner.Text(), "event: ")
+					}
+				}
+				close(events)
+			}()
+
+			// well past the server's write timeout, the stream is still open
+			time.Sleep(time.Second)
+			live.Publish("athlete")
+			select {
+			case event, ok := <-events:
+				if !ok || event != "activities" {
+					t.Errorf("got event %q (stream open: %t), want activities", event, ok)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("update was never sent")
+			}
+		})
+	}
+}
+
+func TestLiveHandlerNeedsSession(t *testing.T) {
+	db := newTestDb(t)
+	live := NewLiveUpdates(context.Background())
+
+	rec := httptest.NewRecorder()
+	LiveHandler(rec, httptest.NewRequest(http.MethodGet, "/running/events?username=athlete", nil), db, live)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without a session, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+// Notifier announces newly earned achievements.
+type Notifier interface {
+	Notify(ctx context.Context, username string, achievement *Achievement) error
+}
+
+// NewNotifier creates a notifier from a spec, which is one of:
+//
+//	log                                        - just log notifications (handy for local testing)
+//	smtp://[user:pass@]host:port?from=..&to=.. - send an email
+//	http(s)://...                              - POST a JSON payload to a webhook
+func NewNotifier(spec string) (Notifier, error) {
+	if spec == "log" {
+		return &LogNotifier{}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifier spec %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "smtp":
+		n := &SmtpNotifier{
+			Addr: u.Host,
+			From: u.Query().Get("from"),
+			To:   u.Query()["to"],
+		}
+		if n.From == "" || len(n.To) == 0 {
+			return nil, fmt.Errorf("smtp notifier requires from and to parameters")
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			n.Auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+		}
+		return n, nil
+	case "http", "https":
+		return &WebhookNotifier{Url: spec}, nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier scheme %q", u.Scheme)
+	}
+}
+
+// LogNotifier writes notifications to the log.
+type LogNotifier struct{}
+
+func (n *LogNotifier) Notify(_ context.Context, username string, achievement *Achievement) error {
+	log.Printf("achievement for %s: %s (activity %d on %s)", username, achievement.Description,
+		achievement.ActivityId, achievement.Date.Format("2006-01-02"))
+	return nil
+}
+
+// SmtpNotifier sends notifications as plain-text emails.
+type SmtpNotifier struct {
+	Addr string
+	Auth smtp.Auth // may be nil, e.g. for a local test server
+	From string
+	To   []string
+}
+
+func (n *SmtpNotifier) Notify(_ context.Context, username string, achievement *Achievement) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s: %s\r\n", username, achievement.Description)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	fmt.Fprintf(&msg, "%s earned a new achievement on %s:\r\n\r\n", username, achievement.Date.Format("Mon Jan 2, 2006"))
+	fmt.Fprintf(&msg, "    %s\r\n\r\n", achievement.Description)
+	fmt.Fprintf(&msg, "https://www.strava.com/activities/%d\r\n", achievement.ActivityId)
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, n.To, msg.Bytes())
+}
+
+// WebhookNotifier POSTs notifications as JSON to a URL.
+type WebhookNotifier struct {
+	Url string
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, username string, achievement *Achievement) error {
+	payload := struct {
+		Username    string `json:"username"`
+		Kind        string `json:"kind"`
+		Description string `json:"description"`
+		ActivityId  int64  `json:"activity_id"`
+		Date        string `json:"date"`
+	}{
+		Username:    username,
+		Kind:        achievement.Kind,
+		Description: achievement.Description,
+		ActivityId:  achievement.ActivityId,
+		Date:        achievement.Date.Format(time.RFC3339),
+	}
+
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode payload: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to make request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post: %s", err)
+	}
+	defer internal.DrainAndClose(rsp.Body)
+
+	if err := internal.CheckResponse(rsp); err != nil {
+		return fmt.Errorf("failed to post: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// largest training plan file that may be uploaded
+	maxPlanBytes = 1 << 20
+
+	// a planned workout counts as done if the athlete ran at least this fraction of its distance that day
+	planCompleteFraction = 0.8
+)
+
+// planWorkout is one workout in an athlete's training plan.  Workouts without a distance (like rest days or cross
+// training) are shown, but don't count towards adherence.
+type planWorkout struct {
+	Day         time.Time // midnight UTC
+	Workout     string    // e.g. "easy", "tempo", "long", "rest"
+	Miles       float64
+	Description string
+}
+
+// ReadPlan returns the athlete's training plan, in date order.
+func (db *SqliteDb) ReadPlan(ctx context.Context, username string) ([]*planWorkout, error) {
+	rows, err := db.query.ListPlanWorkouts(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []*planWorkout
+	for _, row := range rows {
+		plan = append(plan, &planWorkout{
+			Day:         row.Day.UTC(),
+			Workout:     row.Workout,
+			Miles:       row.Miles,
+			Description: row.Description,
+		})
+	}
+	return plan, nil
+}
+
+// WritePlan replaces the athlete's training plan.
+func (db *SqliteDb) WritePlan(ctx context.Context, username string, plan []*planWorkout) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := db.query.WithTx(tx)
+	if err := query.DeletePlanWorkouts(ctx, username); err != nil {
+		return fmt.Errorf("failed to delete plan: %w", err)
+	}
+
+	seqs := make(map[time.Time]int64)
+	for _, workout := range plan {
+		seqs[workout.Day]++
+		err := query.InsertPlanWorkout(ctx, storage.InsertPlanWorkoutParams{
+			Username:    username,
+			Day:         workout.Day,
+			Seq:         seqs[workout.Day],
+			Workout:     workout.Workout,
+			Miles:       workout.Miles,
+			Description: workout.Description,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s workout: %w", workout.Day.Format("2006-01-02"), err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// planEntry is a workout as written in an uploaded plan file.
+type planEntry struct {
+	Date        string `yaml:"date"`
+	Distance    string `yaml:"distance"`
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+}
+
+// parsePlan parses an uploaded training plan, which is YAML if the filename says so and CSV otherwise.  CSV files need
+// a header row naming the date, distance and type columns, and may also have a description column.  YAML files are a
+// list of workouts with the same fields, optionally under a "workouts" key.
+func parsePlan(filename string, data []byte) ([]*planWorkout, error) {
+	var entries []*planEntry
+	var err error
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".yaml", ".yml":
+		entries, err = parsePlanYaml(data)
+	default:
+		entries, err = parsePlanCsv(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("plan has no workouts")
+	}
+
+	var plan []*planWorkout
+	for i, entry := range entries {
+		day, err := time.Parse("2006-01-02", strings.TrimSpace(entry.Date))
+		if err != nil {
+			return nil, fmt.Errorf("workout %d: invalid date %q, expected YYYY-MM-DD", i+1, entry.Date)
+		}
+		miles, err := parsePlanDistance(entry.Distance)
+		if err != nil {
+			return nil, fmt.Errorf("workout %d: %w", i+1, err)
+		}
+		plan = append(plan, &planWorkout{
+			Day:         day,
+			Workout:     strings.ToLower(strings.TrimSpace(entry.Type)),
+			Miles:       miles,
+			Description: strings.TrimSpace(entry.Description),
+		})
+	}
+
+	sort.SliceStable(plan, func(i, j int) bool {
+		return plan[i].Day.Before(plan[j].Day)
+	})
+	return plan, nil
+}
+
+func parsePlanYaml(data []byte) ([]*planEntry, error) {
+	var entries []*planEntry
+	if err := yaml.Unmarshal(data, &entries); err == nil {
+		return entries, nil
+	}
+
+	var doc struct {
+		Workouts []*planEntry `yaml:"workouts"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid yaml: %w", err)
+	}
+	return doc.Workouts, nil
+}
+
+func parsePlanCsv(data []byte) ([]*planEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "workout", "workout type":
+			name = "type"
+		case "notes":
+			name = "description"
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"date", "distance", "type"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header has no %q column", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var entries []*planEntry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv: %w", err)
+		}
+		entries = append(entries, &planEntry{
+			Date:        field(record, "date"),
+			Distance:    field(record, "distance"),
+			Type:        field(record, "type"),
+			Description: field(record, "description"),
+		})
+	}
+	return entries, nil
+}
+
+// parsePlanDistance parses a planned distance in miles, or in kilometers with a "km" suffix.  Empty means no distance.
+func parsePlanDistance(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	unitMeters := metersPerMile
+	for _, suffix := range []string{"miles", "mile", "mi", "km", "k"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+			if strings.HasPrefix(suffix, "k") {
+				unitMeters = metersPerKm
+			}
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid distance %q", s)
+	}
+	return f * unitMeters / metersPerMile, nil
+}
+
+// planDayView compares what was planned for a day with what the athlete actually ran.
+type planDayView struct {
+	Date    string
+	Workout string
+	Planned string
+	Actual  string
+	Status  string // "done", "partial", "missed", "today", "upcoming" or "rest"
+}
+
+type planWeekView struct {
+	Label   string
+	Planned string
+	Actual  string
+	Done    int
+	Due     int
+	Current bool
+	Days    []*planDayView
+}
+
+// planView is the athlete's training plan for a year, as shown on the running page.
+type planView struct {
+	First, Last    string
+	Adherence      string // share of the workouts due so far that were done
+	MilesAdherence string // share of the miles due so far that were run
+	Done           int
+	Due            int
+	Weeks          []*planWeekView
+}
+
+// newPlanView matches the year's planned workouts to the runs on the same (UTC) day, and returns nil if nothing was
+// planned for the year.  Days up to yesterday are due; today's workouts count once they are done.
+func newPlanView(plan []*planWorkout, activities []Activity, year int, now time.Time) *planView {
+	var workouts []*planWorkout
+	for _, workout := range plan {
+		if workout.Day.Year() == year {
+			workouts = append(workouts, workout)
+		}
+	}
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	actual := make(map[time.Time]float64)
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type == "Run" {
+			start := activity.StartTime()
+			actual[time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)] += activity.Miles()
+		}
+	}
+
+	// group the workouts by day, and the days by week
+	var days []time.Time
+	byDay := make(map[time.Time][]*planWorkout)
+	for _, workout := range workouts {
+		if len(byDay[workout.Day]) == 0 {
+			days = append(days, workout.Day)
+		}
+		byDay[workout.Day] = append(byDay[workout.Day], workout)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	v := &planView{
+		First: workouts[0].Day.Format("Jan 2"),
+		Last:  workouts[len(workouts)-1].Day.Format("Jan 2"),
+	}
+
+	var week *planWeekView
+	var weekStart time.Time
+	var weekPlanned, weekActual, duePlanned, dueActual float64
+	finishWeek := func() {
+		if week != nil {
+			week.Planned = fmt.Sprintf("%.1f", weekPlanned)
+			week.Actual = fmt.Sprintf("%.1f", weekActual)
+			v.Weeks = append(v.Weeks, week)
+		}
+	}
+	for _, day := range days {
+		if week == nil || !logBucketStart(day, logPeriodWeek).Equal(weekStart) {
+			finishWeek()
+			weekStart = logBucketStart(day, logPeriodWeek)
+			week = &planWeekView{
+				Label:   logBucketLabel(weekStart, logPeriodWeek),
+				Current: !today.Before(weekStart) && today.Before(weekStart.AddDate(0, 0, 7)),
+			}
+			weekPlanned, weekActual = 0, 0
+		}
+
+		var planned float64
+		var names []string
+		for _, workout := range byDay[day] {
+			planned += workout.Miles
+			name := workout.Workout
+			if workout.Description != "" {
+				name += ": " + workout.Description
+			}
+			names = append(names, name)
+		}
+
+		dv := &planDayView{
+			Date:    day.Format("Mon Jan 2"),
+			Workout: strings.Join(names, "; "),
+			Planned: "-",
+			Actual:  "-",
+		}
+		if planned > 0 {
+			dv.Planned = fmt.Sprintf("%.1f", planned)
+		}
+		if actual[day] > 0 {
+			dv.Actual = fmt.Sprintf("%.1f", actual[day])
+		}
+
+		done := planned > 0 && actual[day] >= planCompleteFraction*planned
+		due := planned > 0 && (day.Before(today) || (day.Equal(today) && done))
+		switch {
+		case planned == 0:
+			dv.Status = "rest"
+		case done:
+			dv.Status = "done"
+		case day.Equal(today):
+			dv.Status = "today"
+		case day.After(today):
+			dv.Status = "upcoming"
+		case actual[day] > 0:
+			dv.Status = "partial"
+		default:
+			dv.Status = "missed"
+		}
+
+		if due {
+			week.Due++
+			v.Due++
+			duePlanned += planned
+			dueActual += actual[day]
+			if done {
+				week.Done++
+				v.Done++
+			}
+		}
+		weekPlanned += planned
+		if !day.After(today) {
+			weekActual += actual[day]
+		}
+		week.Days = append(week.Days, dv)
+	}
+	finishWeek()
+
+	if v.Due > 0 {
+		v.Adherence = fmt.Sprintf("%.0f%%", 100*float64(v.Done)/float64(v.Due))
+		v.MilesAdherence = fmt.Sprintf("%.0f%%", 100*dueActual/duePlanned)
+	}
+	return v
+}
+
+// PlanHandler handles form posts that upload (replacing any existing plan) or clear the athlete's training plan, then
+// redirects back to the running page.
+func PlanHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPlanBytes+1<<16) // leave room for the rest of the form
+	if err := r.ParseMultipartForm(maxPlanBytes); err != nil {
+		internal.HttpError(w, http.StatusBadRequest, "failed to parse form: %s", err)
+		return
+	}
+
+	var plan []*planWorkout
+	redirect := "/running/"
+	if r.PostFormValue("action") != "clear" {
+		file, header, err := r.FormFile("plan")
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "no plan file uploaded: %s", err)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxPlanBytes+1))
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "failed to read plan file: %s", err)
+			return
+		}
+		if len(data) > maxPlanBytes {
+			internal.HttpError(w, http.StatusBadRequest, "plan file is too large (max %d bytes)", maxPlanBytes)
+			return
+		}
+
+		plan, err = parsePlan(header.Filename, data)
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid plan: %s", err)
+			return
+		}
+		redirect = fmt.Sprintf("/running/?year=%d", plan[0].Day.Year())
+	}
+
+	if err := db.WritePlan(r.Context(), username, plan); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write plan: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
@@ -0,0 +1,306 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	// length of a "training block", for the purposes of comparing recent mileage on the race countdown
+	trainingBlockDays = 28
+
+	// exponent in Peter Riegel's race time prediction formula, T2 = T1 * (D2/D1)^1.06
+	riegelExponent = 1.06
+
+	// runs shorter than this aren't used to predict race times; the Riegel formula is unreliable from short efforts
+	minPredictionMeters = 3000
+)
+
+// standardDistances are the race distances recognized by name when registering a race.
+var standardDistances = map[string]float64{
+	"5k":       5000,
+	"8k":       8000,
+	"10k":      10000,
+	"15k":      15000,
+	"10mi":     10 * metersPerMile,
+	"half":     21097.5,
+	"marathon": 42195,
+	"50k":      50000,
+}
+
+func (db *SqliteDb) ListRaces(ctx context.Context, username string) ([]storage.StravaRace, error) {
+	return db.query.ListRaces(ctx, username)
+}
+
+func (db *SqliteDb) WriteRace(ctx context.Context, race *storage.InsertRaceParams) error {
+	return db.query.InsertRace(ctx, *race)
+}
+
+func (db *SqliteDb) DeleteRace(ctx context.Context, username string, id int64) error {
+	return db.query.DeleteRace(ctx, storage.DeleteRaceParams{ID: id, Username: username})
+}
+
+// RacesHandler handles form posts that add or delete an athlete's target races, then redirects back to the running
+// page.
+func RacesHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	switch r.PostFormValue("action") {
+	case "add":
+		raceDate, err := time.Parse("2006-01-02", r.PostFormValue("date"))
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid race date %q", r.PostFormValue("date"))
+			return
+		}
+
+		distance, err := parseRaceDistance(r.PostFormValue("distance"))
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid race distance: %s", err)
+			return
+		}
+
+		var goalSeconds int64
+		if s := strings.TrimSpace(r.PostFormValue("goal")); s != "" {
+			goalSeconds, err = parseDuration(s)
+			if err != nil {
+				internal.HttpError(w, http.StatusBadRequest, "invalid goal time: %s", err)
+				return
+			}
+		}
+
+		name := strings.TrimSpace(r.PostFormValue("name"))
+		if name == "" {
+			name = "Race"
+		}
+
+		arg := storage.InsertRaceParams{
+			Username:       username,
+			Name:           name,
+			RaceDate:       raceDate,
+			DistanceMeters: distance,
+			GoalSeconds:    goalSeconds,
+		}
+		if err := db.WriteRace(r.Context(), &arg); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to write race to db: %s", err)
+			return
+		}
+	case "delete":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid race id %q", r.PostFormValue("id"))
+			return
+		}
+		if err := db.DeleteRace(r.Context(), username, id); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to delete race from db: %s", err)
+			return
+		}
+	default:
+		internal.HttpError(w, http.StatusBadRequest, "unknown action %q", r.PostFormValue("action"))
+		return
+	}
+
+	http.Redirect(w, r, "/running/", http.StatusSeeOther)
+}
+
+// raceView is an upcoming race, as shown on the running page.
+type raceView struct {
+	Id       int64
+	Name     string
+	Date     string
+	Distance string
+	GoalTime string
+}
+
+// raceCountdown describes the athlete's next target race and how their training is tracking towards it.
+type raceCountdown struct {
+	Race           *raceView
+	WeeksRemaining int
+	DaysRemaining  int
+	BlockMiles     string
+	PrevBlockMiles string
+	BlockChange    string
+	Predicted      string
+	PredictedFrom  string
+}
+
+// upcomingRaces converts all races on or after today into views, in date order.
+func upcomingRaces(races []storage.StravaRace, now time.Time) []*raceView {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var views []*raceView
+	for _, race := range races {
+		if race.RaceDate.Before(today) {
+			continue
+		}
+
+		view := &raceView{
+			Id:       race.ID,
+			Name:     race.Name,
+			Date:     race.RaceDate.Format("Mon Jan 2, 2006"),
+			Distance: formatRaceDistance(race.DistanceMeters),
+		}
+		if race.GoalSeconds > 0 {
+			view.GoalTime = formatDuration(float64(race.GoalSeconds))
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// newRaceCountdown builds the countdown for the first upcoming race, using the athlete's recent runs for block
+// mileage and the finish time prediction.  Returns nil if there are no upcoming races.
+func newRaceCountdown(races []storage.StravaRace, activities []Activity, now time.Time) *raceCountdown {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var next *storage.StravaRace
+	for i := range races {
+		if !races[i].RaceDate.Before(today) {
+			next = &races[i]
+			break
+		}
+	}
+	if next == nil {
+		return nil
+	}
+
+	days := int(next.RaceDate.Sub(today).Hours() / 24)
+	countdown := &raceCountdown{
+		Race:           upcomingRaces([]storage.StravaRace{*next}, now)[0],
+		WeeksRemaining: days / 7,
+		DaysRemaining:  days % 7,
+	}
+
+	blockStart := now.AddDate(0, 0, -trainingBlockDays)
+	prevBlockStart := blockStart.AddDate(0, 0, -trainingBlockDays)
+
+	var blockMiles, prevBlockMiles float64
+	var best *Activity
+	var bestPrediction float64
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type != "Run" {
+			continue
+		}
+
+		start := activity.StartTime()
+		switch {
+		case start.Before(prevBlockStart) || start.After(now):
+			continue
+		case start.Before(blockStart):
+			prevBlockMiles += activity.Miles()
+		default:
+			blockMiles += activity.Miles()
+		}
+
+		if activity.DistanceMeters < minPredictionMeters || activity.MovingTime <= 0 {
+			continue
+		}
+		prediction := riegelPredict(activity.MovingTime, activity.DistanceMeters, next.DistanceMeters)
+		if best == nil || prediction < bestPrediction {
+			best = activity
+			bestPrediction = prediction
+		}
+	}
+
+	countdown.BlockMiles = fmt.Sprintf("%.1f", blockMiles)
+	countdown.PrevBlockMiles = fmt.Sprintf("%.1f", prevBlockMiles)
+	if prevBlockMiles > 0 {
+		countdown.BlockChange = fmt.Sprintf("%+.0f%%", 100*(blockMiles-prevBlockMiles)/prevBlockMiles)
+	}
+
+	if best != nil {
+		countdown.Predicted = formatDuration(bestPrediction)
+		countdown.PredictedFrom = fmt.Sprintf("%s (%.1f miles in %s)", best.Name, best.Miles(), formatSeconds(best.MovingTime))
+	}
+
+	return countdown
+}
+
+// riegelPredict predicts the time (in seconds) to cover distance d2, given time t1 over distance d1.
+func riegelPredict(t1, d1, d2 float64) float64 {
+	return t1 * math.Pow(d2/d1, riegelExponent)
+}
+
+// parseRaceDistance parses a race distance, which is either a standard name like "10k" or "marathon", or a number
+// of miles, optionally suffixed with "mi" or "km".  Returns the distance in meters.
+func parseRaceDistance(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if d, ok := standardDistances[s]; ok {
+		return d, nil
+	}
+
+	unitMeters := metersPerMile
+	if strings.HasSuffix(s, "km") {
+		unitMeters = metersPerKm
+		s = strings.TrimSuffix(s, "km")
+	} else {
+		s = strings.TrimSuffix(s, "mi")
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("unrecognized distance %q", s)
+	}
+	return f * unitMeters, nil
+}
+
+func formatRaceDistance(meters float64) string {
+	for name, d := range standardDistances {
+		if math.Abs(d-meters) < 50 { // close enough, e.g. "26.2" miles is a marathon
+			switch name {
+			case "half":
+				return "Half Marathon"
+			case "marathon":
+				return "Marathon"
+			case "10mi":
+				return "10 miles"
+			default:
+				return strings.ToUpper(name)
+			}
+		}
+	}
+	return fmt.Sprintf("%.1f miles", meters/metersPerMile)
+}
+
+// parseDuration parses a duration formatted as h:mm:ss or mm:ss into seconds.
+func parseDuration(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("expected h:mm:ss or mm:ss, got %q", s)
+	}
+
+	var secs int64
+	for _, part := range parts {
+		i, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || i < 0 {
+			return 0, fmt.Errorf("expected h:mm:ss or mm:ss, got %q", s)
+		}
+		secs = 60*secs + i
+	}
+	return secs, nil
+}
+
+// formatDuration formats a number of seconds as h:mm:ss (or mm:ss, if under an hour).
+func formatDuration(s float64) string {
+	secs := int64(s + 0.5)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, (secs/60)%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
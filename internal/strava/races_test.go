@@ -0,0 +1,137 @@
+package strava
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+func TestRiegelPredict(t *testing.T) {
+	tests := []struct {
+		name   string
+		t1, d1 float64
+		d2     float64
+		want   float64
+	}{
+		{"same distance", 1200, 5000, 5000, 1200},
+		{"5k to 10k", 1200, 5000, 10000, 2501.918},
+		{"half to marathon", 5400, 21097.5, 42195, 11258.630},
+		{"10k to 5k", 3000, 10000, 5000, 1438.896},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := riegelPredict(tt.t1, tt.d1, tt.d2); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("riegelPredict(%v, %v, %v) = %v, want %v", tt.t1, tt.d1, tt.d2, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRaceDistance(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "10k", want: 10000},
+		{in: " Marathon ", want: 42195},
+		{in: "half", want: 21097.5},
+		{in: "13.1", want: 13.1 * metersPerMile},
+		{in: "3mi", want: 3 * metersPerMile},
+		{in: "12 km", want: 12000},
+		{in: "", wantErr: true},
+		{in: "-5", wantErr: true},
+		{in: "far", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRaceDistance(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRaceDistance(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("parseRaceDistance(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "20:00", want: 1200},
+		{in: "3:05:09", want: 11109},
+		{in: "0:59", want: 59},
+		{in: "20", wantErr: true},
+		{in: "1:2:3:4", wantErr: true},
+		{in: "1:-5", wantErr: true},
+		{in: "1:xx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDuration(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+		if s := formatDuration(float64(got)); s != tt.in && "0"+s != tt.in {
+			t.Errorf("formatDuration(%d) = %q, want %q", got, s, tt.in)
+		}
+	}
+}
+
+func TestNewRaceCountdown(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	races := []storage.StravaRace{
+		{ID: 1, Name: "Last year's", RaceDate: time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC), DistanceMeters: 10000},
+		{ID: 2, Name: "Spring 10k", RaceDate: time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC), DistanceMeters: 10000},
+	}
+	run := func(name string, daysAgo int, meters, seconds float64) Activity {
+		return Activity{
+			Name:           name,
+			Type:           "Run",
+			DistanceMeters: meters,
+			MovingTime:     seconds,
+			StartDate:      now.AddDate(0, 0, -daysAgo).Format(time.RFC3339),
+		}
+	}
+	activities := []Activity{
+		run("tempo", 3, 5000, 1200),       // predicts 41:42
+		run("easy", 10, 8000, 2880),       // predicts 1:00:24
+		run("strides", 5, 1000, 180),      // too short to predict from
+		run("last block", 40, 5000, 1500), // counts towards the previous block
+		{Name: "ride", Type: "Ride", DistanceMeters: 30000, MovingTime: 3600, StartDate: now.AddDate(0, 0, -1).Format(time.RFC3339)},
+	}
+
+	countdown := newRaceCountdown(races, activities, now)
+	if countdown == nil {
+		t.Fatal("newRaceCountdown returned nil, want a countdown to the spring 10k")
+	}
+	if countdown.Race.Name != "Spring 10k" || countdown.WeeksRemaining != 2 || countdown.DaysRemaining != 2 {
+		t.Errorf("countdown is to %q in %d weeks, %d days; want Spring 10k in 2 weeks, 2 days",
+			countdown.Race.Name, countdown.WeeksRemaining, countdown.DaysRemaining)
+	}
+	if countdown.Predicted != "41:42" {
+		t.Errorf("predicted %s, want 41:42 (from the tempo run)", countdown.Predicted)
+	}
+	if countdown.BlockMiles != "8.7" || countdown.PrevBlockMiles != "3.1" {
+		t.Errorf("block miles %s (previous %s), want 8.7 (previous 3.1)", countdown.BlockMiles, countdown.PrevBlockMiles)
+	}
+
+	if countdown := newRaceCountdown(races[:1], activities, now); countdown != nil {
+		t.Errorf("newRaceCountdown with only past races = %+v, want nil", countdown)
+	}
+}
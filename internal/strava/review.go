@@ -0,0 +1,381 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ianrose14/website/internal"
+)
+
+const (
+	// the card is sized for Open Graph previews
+	reviewWidth  = 1200
+	reviewHeight = 630
+	reviewMargin = 60
+
+	// the cumulative mileage chart along the bottom of the card
+	reviewChartTop    = 390
+	reviewChartBottom = 560
+)
+
+var (
+	reviewBackground = color.RGBA{R: 0x33, G: 0x68, B: 0xFF, A: 0xFF} // matches the running pages
+	reviewText       = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	reviewFaint      = color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x60}
+	reviewHighlight  = color.RGBA{R: 0xCE, G: 0xD8, B: 0x2F, A: 0xFF}
+)
+
+// yearReview sums up an athlete's year of running, for the year in review card.
+type yearReview struct {
+	Year           int
+	Miles          float64
+	Runs           int
+	LongestMiles   float64
+	BestMonth      time.Month
+	BestMonthMiles float64
+	Cumulative     []float64 // miles run by the end of each day of the year
+}
+
+// newYearReview sums up the runs in the given year.  Only runs that the athlete would show to others are included,
+// since the card is meant to be shared.
+func newYearReview(activities []Activity, year int) *yearReview {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	days := int(start.AddDate(1, 0, 0).Sub(start).Hours() / 24)
+
+	review := &yearReview{Year: year, Cumulative: make([]float64, days)}
+	var months [12]float64
+	for i := range activities {
+		activity := &activities[i]
+		t := activity.StartTime()
+		if activity.Type != "Run" || !isShareable(activity) || t.Year() != year {
+			continue
+		}
+
+		miles := activity.Miles()
+		review.Miles += miles
+		review.Runs++
+		if miles > review.LongestMiles {
+			review.LongestMiles = miles
+		}
+		months[t.Month()-1] += miles
+		review.Cumulative[t.YearDay()-1] += miles
+	}
+
+	for day := 1; day < days; day++ {
+		review.Cumulative[day] += review.Cumulative[day-1]
+	}
+	for i, miles := range months {
+		if miles > review.BestMonthMiles {
+			review.BestMonth = time.Month(i + 1)
+			review.BestMonthMiles = miles
+		}
+	}
+	return review
+}
+
+// reviewStat is one of the big numbers across the middle of the card.
+type reviewStat struct {
+	Value  string
+	Label  string
+	Detail string
+}
+
+// drawYearReview draws the athlete's year in review card.
+func drawYearReview(review *yearReview, username string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, reviewWidth, reviewHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: reviewBackground}, image.Point{}, draw.Src)
+
+	drawText(img, reviewMargin, reviewMargin, 6, fmt.Sprintf("%d YEAR IN REVIEW", review.Year), reviewText)
+	drawText(img, reviewMargin, reviewMargin+60, 4, truncateText(username, (reviewWidth-2*reviewMargin)/glyphAdvance(4)), reviewHighlight)
+
+	stats := []*reviewStat{
+		{Value: fmt.Sprintf("%.0f", review.Miles), Label: "MILES"},
+		{Value: strconv.Itoa(review.Runs), Label: "RUNS"},
+		{Value: fmt.Sprintf("%.1f", review.LongestMiles), Label: "LONGEST RUN", Detail: "MILES"},
+		{Value: "-", Label: "BEST MONTH"},
+	}
+	if review.BestMonthMiles > 0 {
+		stats[3].Value = strings.ToUpper(review.BestMonth.String()[:3])
+		stats[3].Detail = fmt.Sprintf("%.0f MILES", review.BestMonthMiles)
+	}
+
+	columnWidth := (reviewWidth - 2*reviewMargin) / len(stats)
+	for i, stat := range stats {
+		x := reviewMargin + i*columnWidth
+		drawText(img, x, 200, 8, stat.Value, reviewText)
+		drawText(img, x, 275, 3, stat.Label, reviewText)
+		drawText(img, x, 305, 3, stat.Detail, reviewFaint)
+	}
+
+	drawCumulativeChart(img, review)
+	return img
+}
+
+// drawCumulativeChart draws the miles run so far through the year as a filled area, with a tick at the start of each
+// month.
+func drawCumulativeChart(img *image.RGBA, review *yearReview) {
+	left, right := reviewMargin, reviewWidth-reviewMargin
+	height := reviewChartBottom - reviewChartTop
+	drawText(img, left, reviewChartTop-25, 2, "CUMULATIVE MILES", reviewFaint)
+
+	if total := review.Cumulative[len(review.Cumulative)-1]; total > 0 {
+		for x := left; x < right; x++ {
+			day := (x - left) * len(review.Cumulative) / (right - left)
+			h := int(review.Cumulative[day] / total * float64(height))
+			top := reviewChartBottom - h
+			fillRect(img, image.Rect(x, top, x+1, reviewChartBottom), reviewFaint)
+			fillRect(img, image.Rect(x, top-2, x+1, top+1), reviewText)
+		}
+	}
+
+	fillRect(img, image.Rect(left, reviewChartBottom, right, reviewChartBottom+2), reviewText)
+	for month := time.January; month <= time.December; month++ {
+		day := time.Date(review.Year, month, 1, 0, 0, 0, 0, time.UTC).YearDay() - 1
+		x := left + day*(right-left)/len(review.Cumulative)
+		fillRect(img, image.Rect(x, reviewChartBottom, x+2, reviewChartBottom+8), reviewText)
+		drawText(img, x+4, reviewChartBottom+12, 2, month.String()[:1], reviewFaint)
+	}
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, &image.Uniform{C: c}, image.Point{}, draw.Over)
+}
+
+// glyphAdvance is the width taken up by each character of text drawn at the given scale.
+func glyphAdvance(scale int) int {
+	return (glyphWidth + 1) * scale
+}
+
+// truncateText shortens s to at most n characters, marking that it was cut off.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+// drawText draws s in the card's bitmap font, with its top left corner at (x, y) and each font pixel scaled up to a
+// scale x scale square.  Lower case letters are drawn as upper case.
+func drawText(img *image.RGBA, x, y, scale int, s string, c color.Color) {
+	for _, r := range s {
+		glyph, ok := glyphs[unicode.ToUpper(r)]
+		if !ok {
+			glyph = glyphs['?']
+		}
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<(glyphWidth-1-col)) != 0 {
+					px, py := x+col*scale, y+row*scale
+					fillRect(img, image.Rect(px, py, px+scale, py+scale), c)
+				}
+			}
+		}
+		x += glyphAdvance(scale)
+	}
+}
+
+// reviewAthlete returns whose year in review was asked for: the athlete with the share slug in the "u" param, or
+// else the signed-in athlete.  slug is the athlete's share slug if they have turned sharing on, and is otherwise
+// empty.  username is empty if there is no such athlete.
+func reviewAthlete(ctx context.Context, r *http.Request, db *SqliteDb) (username, slug string, err error) {
+	if u := r.URL.Query().Get("u"); u != "" {
+		settings, err := db.ReadShareSettingsBySlug(ctx, u)
+		if err != nil || settings == nil || !settings.Enabled {
+			return "", "", err
+		}
+		return settings.Username, settings.Slug, nil
+	}
+
+	username, err = signedInAthlete(r, db)
+	if err != nil || username == "" {
+		return "", "", err
+	}
+	settings, err := db.ReadShareSettings(ctx, username)
+	if err != nil {
+		return "", "", err
+	}
+	if settings != nil && settings.Enabled {
+		slug = settings.Slug
+	}
+	return username, slug, nil
+}
+
+// absoluteUrl returns the full URL of the (already escaped) path on this site, as needed for Open Graph tags.  The
+// Host header is the client's to choose, so only configured hosts make it into the URL (see redirectHost).
+func absoluteUrl(r *http.Request, account *ApiParams, path string) string {
+	u := url.URL{Scheme: "https", Host: redirectHost(r, account)}
+	if account.Dev && isLocalhost(u.Hostname()) {
+		u.Scheme = "http"
+	}
+	return u.String() + path
+}
+
+// ReviewHandler serves an athlete's year in review: /running/review/{year}.png is the card itself, and
+// /running/review/{year} is a page that shows it, with Open Graph tags so that links to it preview nicely.  Anyone
+// may see the review of an athlete who has turned on their public page (by adding its slug as the "u" param), but
+// otherwise only the athlete may.
+func ReviewHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	name := strings.TrimPrefix(r.URL.Path, "/running/review/")
+	isImage := strings.HasSuffix(name, ".png")
+	year, err := strconv.Atoi(strings.TrimSuffix(name, ".png"))
+	if err != nil || year < 1970 || year > time.Now().Year() {
+		internal.HttpError(w, http.StatusNotFound, "no year in review at %q", r.URL.Path)
+		return
+	}
+
+	username, slug, err := reviewAthlete(r.Context(), r, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read athlete: %s", err)
+		return
+	}
+	shared := r.URL.Query().Get("u") != ""
+	if username == "" {
+		if shared || isImage {
+			internal.HttpError(w, http.StatusNotFound, "no year in review at %q", r.URL.Path)
+			return
+		}
+		http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+		return
+	}
+
+	imagePath := fmt.Sprintf("/running/review/%d.png", year)
+	pagePath := fmt.Sprintf("/running/review/%d", year)
+	if slug != "" {
+		imagePath += "?u=" + url.QueryEscape(slug)
+		pagePath += "?u=" + url.QueryEscape(slug)
+	}
+
+	if !isImage {
+		args := struct {
+			Username string
+			Year     int
+			Title    string
+			ImageUrl string
+			PageUrl  string
+			Shared   bool
+		}{
+			Username: username,
+			Year:     year,
+			Title:    fmt.Sprintf("%s's %d year in review", username, year),
+			ImageUrl: absoluteUrl(r, account, imagePath),
+			PageUrl:  absoluteUrl(r, account, pagePath),
+			Shared:   slug != "",
+		}
+		if err := tmpl.Execute(w, &args); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+			return
+		}
+		return
+	}
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	finish := start.AddDate(1, 0, 0)
+	if now := time.Now(); now.Before(finish) {
+		finish = now
+	}
+
+	// only the athlete's own views sync with Strava; anyone else's are drawn from stored activities
+	var activities []Activity
+	if !shared {
+		activities, err = syncActivities(r.Context(), username, start, finish, db, account)
+		if err != nil {
+			log.Printf("failed to sync activities for %q's year in review, using stored activities: %s", username, err)
+		}
+	}
+	if shared || err != nil {
+		activities, err = db.LoadActivities(r.Context(), username, start, finish)
+		if err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to read activities: %s", err)
+			return
+		}
+	}
+
+	decisions, err := db.ReadDuplicateDecisions(r.Context(), username)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read duplicate decisions: %s", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawYearReview(newYearReview(decisions.Counted(activities), year), username)); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to encode image: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	// the athlete's own card may be private, so only shared cards may be cached by others
+	if shared {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("failed to write year in review image: %s", err)
+	}
+}
+
+// glyphWidth is the width, in font pixels, of each glyph in the card's bitmap font.  Glyphs are 7 rows high, and each
+// row's pixels are the low bits of its byte, leftmost first.
+const glyphWidth = 5
+
+var glyphs = map[rune][7]byte{
+	' ':  {},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'.':  {0, 0, 0, 0, 0, 0b01100, 0b01100},
+	',':  {0, 0, 0, 0, 0b01100, 0b00100, 0b01000},
+	':':  {0, 0b01100, 0b01100, 0, 0b01100, 0b01100, 0},
+	'-':  {0, 0, 0, 0b11111, 0, 0, 0},
+	'_':  {0, 0, 0, 0, 0, 0, 0b11111},
+	'/':  {0b00001, 0b00010, 0b00010, 0b00100, 0b01000, 0b01000, 0b10000},
+	'%':  {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'\'': {0b00100, 0b00100, 0b01000, 0, 0, 0, 0},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0, 0b00100},
+}
@@ -0,0 +1,31 @@
+package strava
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAbsoluteUrl(t *testing.T) {
+	tests := []struct {
+		host string
+		dev  bool
+		want string
+	}{
+		{"www.example.com", false, "https://www.example.com/running/review/2024"},
+		{"Example.com:443", false, "https://example.com/running/review/2024"},
+		{"evil.example.net", false, "https://www.example.com/running/review/2024"},
+		{"localhost:8080", true, "http://localhost:8080/running/review/2024"},
+		{"localhost:8080", false, "https://www.example.com/running/review/2024"},
+		{"evil.example.net", true, "https://www.example.com/running/review/2024"},
+	}
+
+	for _, tt := range tests {
+		account := &ApiParams{Hosts: []string{"www.example.com", "example.com"}, Dev: tt.dev}
+		r := httptest.NewRequest(http.MethodGet, "/running/review/2024", nil)
+		r.Host = tt.host
+		if got := absoluteUrl(r, account, "/running/review/2024"); got != tt.want {
+			t.Errorf("absoluteUrl() for host %q (dev: %t) = %q, want %q", tt.host, tt.dev, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// goalSchedule spreads an athlete's yearly goals over the year according to their plan, e.g. to allow for an
+// off-season or a marathon build.  Without a plan, goals accrue evenly over the year.
+type goalSchedule struct {
+	Year int
+
+	// Period is logPeriodMonth or logPeriodWeek, or empty for an even spread.  Weekly plans count weeks from Jan 1.
+	Period string
+
+	// Weights are the relative amounts planned for each month or week, e.g. miles per month.  Only their proportions
+	// matter.
+	Weights []float64
+}
+
+// ReadGoalSchedule returns the athlete's goal schedule for the given year, which is an even spread if they haven't
+// planned one.
+func (db *SqliteDb) ReadGoalSchedule(ctx context.Context, username string, year int) (*goalSchedule, error) {
+	row, err := db.query.FetchGoalSchedule(ctx, storage.FetchGoalScheduleParams{Username: username, Year: int64(year)})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &goalSchedule{Year: year}, nil
+		}
+		return nil, err
+	}
+
+	weights, err := parseScheduleWeights(row.Period, row.Weights)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored schedule: %w", err)
+	}
+	return &goalSchedule{Year: year, Period: row.Period, Weights: weights}, nil
+}
+
+// WriteGoalSchedule saves the athlete's goal schedule, or removes it if the schedule is an even spread.
+func (db *SqliteDb) WriteGoalSchedule(ctx context.Context, username string, schedule *goalSchedule) error {
+	if schedule.Period == "" {
+		return db.query.DeleteGoalSchedule(ctx, storage.DeleteGoalScheduleParams{Username: username, Year: int64(schedule.Year)})
+	}
+
+	return db.query.UpsertGoalSchedule(ctx, storage.UpsertGoalScheduleParams{
+		Username:    username,
+		Year:        int64(schedule.Year),
+		Period:      schedule.Period,
+		Weights:     formatScheduleWeights(schedule.Weights),
+		UpdatedTime: time.Now(),
+	})
+}
+
+// parseScheduleWeights parses a comma- or space-separated list of weights, one per month or week of the year.
+func parseScheduleWeights(period, s string) ([]float64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+
+	var weights []float64
+	var sum float64
+	for _, field := range fields {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("invalid weight %q", field)
+		}
+		weights = append(weights, f)
+		sum += f
+	}
+
+	switch period {
+	case logPeriodMonth:
+		if len(weights) != 12 {
+			return nil, fmt.Errorf("expected 12 monthly amounts, got %d", len(weights))
+		}
+	case logPeriodWeek:
+		if len(weights) != 52 && len(weights) != 53 {
+			return nil, fmt.Errorf("expected 52 or 53 weekly amounts, got %d", len(weights))
+		}
+	default:
+		return nil, fmt.Errorf("unknown schedule period %q", period)
+	}
+
+	if sum <= 0 {
+		return nil, fmt.Errorf("schedule must plan some amount")
+	}
+	return weights, nil
+}
+
+func formatScheduleWeights(weights []float64) string {
+	strs := make([]string, len(weights))
+	for i, w := range weights {
+		strs[i] = strconv.FormatFloat(w, 'f', -1, 64)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// dayShare returns the share of the year's goals that is planned for the given day.
+func (s *goalSchedule) dayShare(day time.Time) float64 {
+	if day.Year() != s.Year {
+		return 0
+	}
+	if s.Period == "" {
+		return 1.0 / 365 // matches the pro-rating in yearBounds
+	}
+
+	var sum float64
+	for _, w := range s.Weights {
+		sum += w
+	}
+
+	if s.Period == logPeriodMonth {
+		days := time.Date(s.Year, day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		return s.Weights[day.Month()-1] / sum / float64(days)
+	}
+
+	week := (day.YearDay() - 1) / 7
+	if week >= len(s.Weights) {
+		return 0
+	}
+	days := 7
+	if last := time.Date(s.Year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay(); 7*week+7 > last {
+		days = last - 7*week // the short final week of the year
+	}
+	return s.Weights[week] / sum / float64(days)
+}
+
+// Fraction returns the share of the year's goals that is planned for [start, end), which are expected to be midnight
+// UTC.
+func (s *goalSchedule) Fraction(start, end time.Time) float64 {
+	var fraction float64
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		fraction += s.dayShare(day)
+	}
+	return fraction
+}
+
+// mileagePlan is the athlete's planned mileage over one or more years, combining their distance goal and schedule
+// for each year.
+type mileagePlan struct {
+	goals     map[int]float64
+	schedules map[int]*goalSchedule
+}
+
+// loadMileagePlan loads the athlete's mileage plan for the years from startYear through endYear.
+func loadMileagePlan(ctx context.Context, username string, startYear, endYear int, db *SqliteDb) (*mileagePlan, error) {
+	plan := &mileagePlan{goals: make(map[int]float64), schedules: make(map[int]*goalSchedule)}
+	for year := startYear; year <= endYear; year++ {
+		goals, err := yearGoals(ctx, username, year, db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %d goals: %w", year, err)
+		}
+		for _, goal := range goals {
+			if goal.Kind == GoalDistance {
+				plan.goals[year] = goal.Target
+			}
+		}
+
+		plan.schedules[year], err = db.ReadGoalSchedule(ctx, username, year)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %d goal schedule: %w", year, err)
+		}
+	}
+	return plan, nil
+}
+
+// Miles returns the mileage planned for [start, end), or zero if the athlete has no mileage goal for that time.
+func (p *mileagePlan) Miles(start, end time.Time) float64 {
+	var miles float64
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if schedule := p.schedules[day.Year()]; schedule != nil {
+			miles += p.goals[day.Year()] * schedule.dayShare(day)
+		}
+	}
+	return miles
+}
+
+// scheduleSetting is the goal schedule part of the goal settings form.
+type scheduleSetting struct {
+	Period  string
+	Weights string
+}
+
+func newScheduleSetting(schedule *goalSchedule) *scheduleSetting {
+	return &scheduleSetting{Period: schedule.Period, Weights: formatScheduleWeights(schedule.Weights)}
+}
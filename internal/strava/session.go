@@ -0,0 +1,106 @@
+package strava
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// Athletes are signed in on a browser by a session cookie, which is set when they connect their Strava account (see
+// TokenHandler).  A username in the query string says who a request is about, not who made it, so anything that reads
+// or changes an athlete's own data must find them with requireAthlete instead.
+const (
+	sessionCookie   = "session"
+	sessionLifetime = 30 * 24 * time.Hour
+)
+
+// startSession signs the athlete in on the requesting browser.
+func startSession(w http.ResponseWriter, r *http.Request, username string, db *SqliteDb) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	if err := db.query.DeleteExpiredSessions(r.Context(), now); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	arg := storage.InsertSessionParams{
+		ID:          sessionId(token),
+		Username:    username,
+		CreatedTime: now,
+		ExpiresAt:   now.Add(sessionLifetime),
+	}
+	if err := db.query.InsertSession(r.Context(), arg); err != nil {
+		return err
+	}
+
+	// Lax keeps the cookie off of cross-site form posts, so other sites can't change settings on an athlete's behalf
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  arg.ExpiresAt,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionId returns the key that a session is stored under, which is a hash of its cookie.
+func sessionId(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signedInAthlete returns the athlete that is signed in on the requesting browser, or "" if there isn't one.
+func signedInAthlete(r *http.Request, db *SqliteDb) (string, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return "", nil
+	}
+
+	session, err := db.query.FetchSession(r.Context(), sessionId(c.Value))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return "", nil
+	}
+	return session.Username, nil
+}
+
+// requireAthlete returns the athlete that is signed in on the requesting browser.  If there isn't one, it sends them
+// off to sign in with Strava and returns false.
+func requireAthlete(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) (string, bool) {
+	username, err := signedInAthlete(r, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read session: %s", err)
+		return "", false
+	}
+	if username != "" {
+		return username, true
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		// come back to the same page afterwards
+		http.Redirect(w, r, getAuthUrlReturningTo(r, account, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+	} else {
+		http.Redirect(w, r, getAuthUrl(r, account), http.StatusSeeOther)
+	}
+	return "", false
+}
@@ -0,0 +1,273 @@
+package strava
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+// ReadShareSettings returns the athlete's public share settings, or nil if they have never turned sharing on.
+func (db *SqliteDb) ReadShareSettings(ctx context.Context, username string) (*storage.StravaShareSetting, error) {
+	row, err := db.query.FetchShareSettings(ctx, username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &row, nil
+}
+
+// ReadShareSettingsBySlug returns the share settings with the given slug, or nil if there are none.
+func (db *SqliteDb) ReadShareSettingsBySlug(ctx context.Context, slug string) (*storage.StravaShareSetting, error) {
+	row, err := db.query.FetchShareSettingsBySlug(ctx, slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &row, nil
+}
+
+func (db *SqliteDb) WriteShareSettings(ctx context.Context, settings *storage.UpsertShareSettingsParams) error {
+	return db.query.UpsertShareSettings(ctx, *settings)
+}
+
+// shareView describes the athlete's share settings, as shown on the running page.
+type shareView struct {
+	Enabled        bool
+	Url            string
+	BadgeUrl       string
+	HideNames      bool
+	HideStartTimes bool
+}
+
+func newShareView(settings *storage.StravaShareSetting) *shareView {
+	if settings == nil {
+		return &shareView{}
+	}
+	return &shareView{
+		Enabled:        settings.Enabled,
+		Url:            "/running/u/" + settings.Slug,
+		BadgeUrl:       "/running/badge.svg?u=" + settings.Slug,
+		HideNames:      settings.HideNames,
+		HideStartTimes: settings.HideStartTimes,
+	}
+}
+
+// ShareSettingsHandler handles form posts that change the athlete's public share settings, then redirects back to the
+// running page.
+func ShareSettingsHandler(w http.ResponseWriter, r *http.Request, db *SqliteDb, account *ApiParams) {
+	if r.Method != http.MethodPost {
+		internal.HttpError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		return
+	}
+
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	current, err := db.ReadShareSettings(r.Context(), username)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read share settings: %s", err)
+		return
+	}
+
+	arg := storage.UpsertShareSettingsParams{
+		Username:       username,
+		Enabled:        r.PostFormValue("enabled") == "on",
+		HideNames:      r.PostFormValue("hide_names") == "on",
+		HideStartTimes: r.PostFormValue("hide_start_times") == "on",
+		UpdatedTime:    time.Now(),
+	}
+
+	// a new slug invalidates any previously shared links
+	if current == nil || r.PostFormValue("action") == "regenerate" {
+		arg.Slug, err = newShareSlug()
+		if err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to generate share link: %s", err)
+			return
+		}
+	} else {
+		arg.Slug = current.Slug
+	}
+
+	if err := db.WriteShareSettings(r.Context(), &arg); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to write share settings: %s", err)
+		return
+	}
+
+	http.Redirect(w, r, "/running/", http.StatusSeeOther)
+}
+
+// ShareHandler serves an athlete's public, read-only progress page, if they have turned sharing on.  Activities that
+// the athlete marked as private or hidden on Strava are never included.
+func ShareHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	slug := strings.TrimPrefix(r.URL.Path, "/running/u/")
+
+	settings, err := db.ReadShareSettingsBySlug(r.Context(), slug)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read share settings: %s", err)
+		return
+	}
+	if settings == nil || !settings.Enabled {
+		internal.HttpError(w, http.StatusNotFound, "no shared page at %q", r.URL.Path)
+		return
+	}
+
+	year := time.Now().Year()
+	if s := r.URL.Query().Get("year"); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			year = i
+		}
+	}
+
+	progress, err := loadSharedProgress(r.Context(), settings.Username, year, db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read progress: %s", err)
+		return
+	}
+
+	args := struct {
+		Username        string
+		Year            int
+		Activities      []*activityRow
+		RunCount        int
+		MilesTotal      string
+		MilesYearGoal   string
+		MilesScaledGoal string
+		Progress        string
+		GaugeRotate     int
+	}{
+		Username:        settings.Username,
+		Year:            year,
+		RunCount:        len(progress.Runs),
+		MilesTotal:      fmt.Sprintf("%.1f", progress.Miles),
+		MilesYearGoal:   strconv.FormatFloat(progress.GoalMiles, 'f', -1, 64),
+		MilesScaledGoal: fmt.Sprintf("%.1f", progress.ScaledGoalMiles),
+		GaugeRotate:     progress.GaugeRotate(),
+	}
+	if progress.HasPace() {
+		args.Progress = fmt.Sprintf("%.0f", progress.Progress)
+	}
+
+	for _, activity := range progress.Runs {
+		args.Activities = append(args.Activities, newSharedActivityRow(activity, settings))
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
+
+// sharedProgress is an athlete's mileage for a year, as shown to anyone they have shared it with.
+type sharedProgress struct {
+	Runs            []*Activity
+	Miles           float64
+	GoalMiles       float64
+	ScaledGoalMiles float64 // the goal, scaled to how much of the year has passed
+	Progress        float64 // percent of target pace; see HasPace
+}
+
+// HasPace returns whether there is a target pace to compare with, which there isn't while the scaled goal is zero (as
+// it is for a zero goal, or a schedule that plans nothing so far).
+func (p *sharedProgress) HasPace() bool {
+	return !math.IsNaN(p.Progress) && !math.IsInf(p.Progress, 0)
+}
+
+// GaugeRotate returns how far to turn the progress gauge, where 90 degrees or more means on (or ahead of) target pace.
+func (p *sharedProgress) GaugeRotate() int {
+	if !p.HasPace() {
+		return 0
+	}
+	return int(90.0 * p.Progress / 100)
+}
+
+// loadSharedProgress sums up the athlete's runs for the year, leaving out any that they marked as private or hidden on
+// Strava.  Runs are read from the local activity store (which is synced whenever the athlete views their own running
+// page, and by stravasync), so that anonymous views never use up the Strava API rate limit.
+func loadSharedProgress(ctx context.Context, username string, year int, db *SqliteDb) (*sharedProgress, error) {
+	now := time.Now()
+	queryStart, queryEnd, _ := yearBounds(year, now)
+
+	plan, err := loadMileagePlan(ctx, username, year, year, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read goals: %w", err)
+	}
+	if plan.goals[year] == 0 {
+		// the athlete only has other kinds of goals, but shared progress is all about mileage
+		plan.goals[year] = float64(defaultGoal(year))
+	}
+
+	activities, err := db.LoadActivities(ctx, username, queryStart, queryEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load activities: %w", err)
+	}
+
+	decisions, err := db.ReadDuplicateDecisions(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read duplicate decisions: %w", err)
+	}
+	activities = decisions.Counted(activities)
+
+	progress := &sharedProgress{
+		GoalMiles:       plan.goals[year],
+		ScaledGoalMiles: plan.Miles(queryStart, queryEnd),
+	}
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type != "Run" || !isShareable(activity) {
+			continue
+		}
+
+		progress.Runs = append(progress.Runs, activity)
+		progress.Miles += activity.Miles()
+	}
+	progress.Progress = 100 * progress.Miles / progress.ScaledGoalMiles
+	return progress, nil
+}
+
+// isShareable returns whether the activity may be shown to anyone other than its owner.
+func isShareable(activity *Activity) bool {
+	return !activity.Private && !activity.HideFromHome
+}
+
+// newSharedActivityRow is like newActivityRow, but redacts whatever the athlete asked to hide, and doesn't link to
+// the (owner-only) activity page.
+func newSharedActivityRow(activity *Activity, settings *storage.StravaShareSetting) *activityRow {
+	redacted := *activity
+	if settings.HideNames {
+		redacted.Name = redacted.Type
+	}
+	if settings.HideStartTimes {
+		redacted.StartDate = ""
+	}
+
+	row := newActivityRow(&redacted)
+	row.Url = ""
+	return row
+}
+
+func newShareSlug() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,30 @@
+package strava
+
+import "testing"
+
+func TestSharedProgressPace(t *testing.T) {
+	tests := []struct {
+		name        string
+		miles, goal float64
+		wantPace    bool
+		wantRotate  int
+	}{
+		{"on pace", 100, 100, true, 90},
+		{"behind", 50, 100, true, 45},
+		{"no miles yet", 0, 100, true, 0},
+		{"nothing planned yet", 10, 0, false, 0},
+		{"nothing planned or run yet", 0, 0, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &sharedProgress{Miles: tt.miles, ScaledGoalMiles: tt.goal, Progress: 100 * tt.miles / tt.goal}
+			if got := p.HasPace(); got != tt.wantPace {
+				t.Errorf("HasPace() = %t with progress %v, want %t", got, p.Progress, tt.wantPace)
+			}
+			if got := p.GaugeRotate(); got != tt.wantRotate {
+				t.Errorf("GaugeRotate() = %d, want %d", got, tt.wantRotate)
+			}
+		})
+	}
+}
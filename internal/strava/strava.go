@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +19,9 @@ import (
 	"github.com/ianrose14/website/internal/storage"
 )
 
+// Stored access tokens are refreshed once they are this close to expiring.
+const accessTokenMinLifetime = 10 * time.Minute
+
 var (
 	ErrNeedsAuth = errors.New("needs auth")
 
@@ -34,7 +39,25 @@ var (
 type ApiParams struct {
 	ClientId     string
 	ClientSecret string
-	Hostname     string
+
+	// Hosts are the hostnames that OAuth may redirect back to; the first is used for requests to any other host.
+	Hosts []string
+
+	// Dev, if set, also allows OAuth to redirect back to localhost over plain http.
+	Dev bool
+
+	// Demo, if set, serves fixture athletes and activities instead of talking to Strava.  See NewDemoClient.
+	Demo bool
+
+	// Client is used for all requests to the Strava API; if nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (p *ApiParams) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
 }
 
 type KVDB interface {
@@ -111,11 +134,13 @@ func (db *MemoryDatabase) Write(_ context.Context, tokens *storage.InsertStravaT
 }
 
 type SqliteDb struct {
-	query *storage.Queries
+	db        *storage.DB
+	query     *storage.Queries
+	listeners []ActivityListener
 }
 
-func NewSqliteDb(db *sql.DB) KVDB {
-	return &SqliteDb{query: storage.New(db)}
+func NewSqliteDb(db *storage.DB) *SqliteDb {
+	return &SqliteDb{db: db, query: storage.New(db)}
 }
 
 func (db *SqliteDb) Read(ctx context.Context, username string) (*storage.FetchStravaTokensRow, error) {
@@ -134,18 +159,62 @@ func (db *SqliteDb) Write(ctx context.Context, tokens *storage.InsertStravaToken
 	return db.query.InsertStravaTokens(ctx, *tokens)
 }
 
+// Values of Activity.WorkoutType for runs, as defined by the Strava API.
+const (
+	WorkoutTypeDefault = 0
+	WorkoutTypeRace    = 1
+	WorkoutTypeLongRun = 2
+	WorkoutTypeWorkout = 3
+)
+
 type Activity struct {
-	Name           string  `json:"name"`
-	DistanceMeters float64 `json:"distance"`
-	MovingTime     float64 `json:"moving_time"`
-	Type           string  `json:"type"`
-	StartDate      string  `json:"start_date"`
+	Id                 int64   `json:"id"`
+	Name               string  `json:"name"`
+	DistanceMeters     float64 `json:"distance"`
+	MovingTime         float64 `json:"moving_time"`
+	ElapsedTime        float64 `json:"elapsed_time"`
+	TotalElevationGain float64 `json:"total_elevation_gain"`
+	Type               string  `json:"type"`
+	StartDate          string  `json:"start_date"`
+	AverageHeartrate   float64 `json:"average_heartrate"`
+	MaxHeartrate       float64 `json:"max_heartrate"`
+	AverageCadence     float64 `json:"average_cadence"`
+	SufferScore        float64 `json:"suffer_score"`
+	WorkoutType        int     `json:"workout_type"`
+	Private            bool    `json:"private"`
+	HideFromHome       bool    `json:"hide_from_home"`
 }
 
 func (a *Activity) Miles() float64 {
 	return 0.621371 * a.DistanceMeters / 1000.
 }
 
+// StartTime parses the activity's start date, returning the zero time if it is malformed.
+func (a *Activity) StartTime() time.Time {
+	t, err := time.Parse(time.RFC3339, a.StartDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (a *Activity) ElevationFeet() float64 {
+	return 3.28084 * a.TotalElevationGain
+}
+
+// StepsPerMinute converts Strava's running cadence, which is reported per leg, to total steps per minute.
+func (a *Activity) StepsPerMinute() float64 {
+	return 2 * a.AverageCadence
+}
+
+func (a *Activity) IsRace() bool {
+	return a.WorkoutType == WorkoutTypeRace
+}
+
+func (a *Activity) IsLongRun() bool {
+	return a.WorkoutType == WorkoutTypeLongRun
+}
+
 type ProfileInfo struct {
 	Username      string `json:"username"`
 	ProfileMedium string `json:"profile_medium"`
@@ -164,7 +233,7 @@ func doStravaQuery(ctx context.Context, sessionId string, start, finish time.Tim
 		return nil, fmt.Errorf("failed to read access token: %s", err)
 	}
 
-	return getActivities(accessToken, start, finish)
+	return getActivities(account, accessToken, start, finish)
 }
 
 func readAccessToken(ctx context.Context, username string, db KVDB, account *ApiParams) (string, error) {
@@ -177,13 +246,18 @@ func readAccessToken(ctx context.Context, username string, db KVDB, account *Api
 		return "", ErrNeedsAuth
 	}
 
+	// no need to refresh if the current access token still has some life left in it
+	if time.Until(tokens.ExpiresAt) > accessTokenMinLifetime {
+		return tokens.AccessToken, nil
+	}
+
 	vals := make(url.Values)
 	vals.Set("client_id", account.ClientId)
 	vals.Set("client_secret", account.ClientSecret)
 	vals.Set("grant_type", "refresh_token")
 	vals.Set("refresh_token", tokens.RefreshToken)
 
-	rsp, err := http.DefaultClient.PostForm("https://www.strava.com/api/v3/oauth/token", vals)
+	rsp, err := account.client().PostForm("https://www.strava.com/api/v3/oauth/token", vals)
 	if err != nil {
 		return "", fmt.Errorf("failed to post: %s", err)
 	}
@@ -230,7 +304,7 @@ func exchangeToken(code string, account *ApiParams) (*AuthResponse, error) {
 	vals.Set("code", code)
 	vals.Set("grant_type", "authorization_code")
 
-	rsp, err := http.DefaultClient.PostForm("https://www.strava.com/oauth/token", vals)
+	rsp, err := account.client().PostForm("https://www.strava.com/oauth/token", vals)
 	if err != nil {
 		return nil, fmt.Errorf("failed to post: %s", err)
 	}
@@ -251,24 +325,131 @@ func formatSeconds(s float64) string {
 	return fmt.Sprintf("%d:%02.0f", minutes, s-float64(60*minutes))
 }
 
-func getAuthUrl(account *ApiParams) string {
-	host := account.Hostname
-	if host == "" {
+// formatHours formats a (typically large) number of seconds as hours and minutes, e.g. "31h05m".
+func formatHours(s float64) string {
+	minutes := int64(s/60. + 0.5)
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+// getAuthUrl returns the Strava authorization URL, which redirects back to the host that the request was made to, so
+// long as it's one of the configured hosts.
+func getAuthUrl(r *http.Request, account *ApiParams) string {
+	return getAuthUrlReturningTo(r, account, "")
+}
+
+// getAuthUrlReturningTo is like getAuthUrl, but the athlete is sent to the given path (instead of the running page)
+// once they have signed in.  The path is passed through the OAuth flow as its state param.
+func getAuthUrlReturningTo(r *http.Request, account *ApiParams, returnPath string) string {
+	if account.Demo {
+		if returnPath != "" {
+			return demoAuthorizePath + "?" + url.Values{"state": {returnPath}}.Encode()
+		}
+		return demoAuthorizePath
+	}
+
+	redirect := url.URL{Scheme: "https", Host: redirectHost(r, account), Path: "/strava/exchange_token/"}
+	if account.Dev && isLocalhost(redirect.Hostname()) {
+		redirect.Scheme = "http"
+	}
+
+	vals := make(url.Values)
+	vals.Set("client_id", account.ClientId)
+	vals.Set("response_type", "code")
+	vals.Set("redirect_uri", redirect.String())
+	vals.Set("approval_prompt", "force")
+	vals.Set("scope", "activity:read_all")
+	if returnPath != "" {
+		vals.Set("state", returnPath)
+	}
+	return "https://www.strava.com/oauth/authorize?" + vals.Encode()
+}
+
+// isReturnPath returns whether the path (from an OAuth state param) is one that athletes may be sent to after signing
+// in, which excludes anything off of this site.
+func isReturnPath(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/running/") &&
+		!strings.HasPrefix(path, "//")
+}
+
+// redirectHost returns the request's host (including any port) if it's allowed as an OAuth redirect target, and
+// otherwise the first configured host.
+func redirectHost(r *http.Request, account *ApiParams) string {
+	host := strings.ToLower(r.Host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	if account.Dev && isLocalhost(hostname) {
+		return host
+	}
+	for _, h := range account.Hosts {
+		// ports are only expected in dev, so don't carry them over to production hosts
+		if hostname == strings.ToLower(h) {
+			return hostname
+		}
+	}
+
+	if len(account.Hosts) > 0 {
+		return account.Hosts[0]
+	}
+	return host
+}
+
+func isLocalhost(hostname string) bool {
+	switch strings.Trim(hostname, "[]") {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	default:
+		return false
+	}
+}
+
+func getActivities(account *ApiParams, accessToken string, start, finish time.Time) ([]Activity, error) {
+	raws, err := getActivitiesRaw(account, accessToken, start, finish)
+	if err != nil {
+		return nil, err
+	}
 
+	activities := make([]Activity, len(raws))
+	for i, raw := range raws {
+		if err := json.Unmarshal(raw, &activities[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse activity: %s", err)
+		}
 	}
+	return activities, nil
+}
 
-	return fmt.Sprintf("https://www.strava.com/oauth/authorize?client_id=" + account.ClientId + "&response_type=code&redirect_uri=https://" + account.Hostname + "/strava/exchange_token/&approval_prompt=force&scope=activity:read_all")
+// getActivitiesRaw is like getActivities, but returns each activity as the raw JSON returned by the Strava API.
+func getActivitiesRaw(account *ApiParams, accessToken string, start, finish time.Time) ([]json.RawMessage, error) {
+	var activities []json.RawMessage
+	for page := 1; ; page++ {
+		raws, err := getActivitiesPage(account, accessToken, start, finish, page)
+		if err != nil {
+			return nil, err
+		}
+
+		activities = append(activities, raws...)
+		if len(raws) < activitiesPageSize {
+			break
+		}
+	}
+
+	log.Printf("got %d activities from %s to %s", len(activities), start, finish)
+	return activities, nil
 }
 
-func getActivities(accessToken string, start, finish time.Time) ([]Activity, error) {
-	urls := fmt.Sprintf("https://www.strava.com/api/v3/athlete/activities?per_page=200&before=%d&after=%d", finish.Unix(), start.Unix())
+func getActivitiesPage(account *ApiParams, accessToken string, start, finish time.Time, page int) ([]json.RawMessage, error) {
+	urls := fmt.Sprintf("https://www.strava.com/api/v3/athlete/activities?per_page=%d&page=%d&before=%d&after=%d",
+		activitiesPageSize, page, finish.Unix(), start.Unix())
 	req, err := http.NewRequest("GET", urls, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %s", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	rsp, err := http.DefaultClient.Do(req)
+	rsp, err := account.client().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get: %s", err)
 	}
@@ -278,23 +459,21 @@ func getActivities(accessToken string, start, finish time.Time) ([]Activity, err
 		return nil, fmt.Errorf("failed to get: %s", err)
 	}
 
-	var activities []Activity
+	var activities []json.RawMessage
 	if err := json.NewDecoder(rsp.Body).Decode(&activities); err != nil {
 		return nil, fmt.Errorf("failed to parse body: %s", err)
 	}
-
-	log.Printf("got %d activities from %s to %s", len(activities), start, finish)
 	return activities, nil
 }
 
-func getProfile(accessToken string) (*ProfileInfo, error) {
+func getProfile(account *ApiParams, accessToken string) (*ProfileInfo, error) {
 	req, err := http.NewRequest("GET", "https://www.strava.com/api/v3/athlete", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %s", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	rsp, err := http.DefaultClient.Do(req)
+	rsp, err := account.client().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get: %s", err)
 	}
@@ -0,0 +1,398 @@
+package strava
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+	"github.com/ianrose14/website/internal/storage"
+)
+
+const (
+	// maximum page size allowed by the /athlete/activities endpoint
+	activitiesPageSize = 200
+
+	// Strava's short-term rate limit resets every 15 minutes (on the quarter hour), and the daily limit at midnight UTC
+	rateLimitWindow = 15 * time.Minute
+
+	// keep this many requests in reserve, so that the webapp can keep working while a sync is running
+	rateLimitReserve = 5
+)
+
+var ErrDailyRateLimit = errors.New("daily rate limit exhausted")
+
+// RateLimiter keeps API usage within Strava's rate limits, based on the usage that Strava reports in the headers of
+// each response.  See https://developers.strava.com/docs/rate-limits/
+type RateLimiter struct {
+	// Logf, if set, is called when the limiter pauses for the rate limit window to reset.
+	Logf func(format string, args ...interface{})
+
+	mu                     sync.Mutex
+	shortLimit, shortUsage int
+	dailyLimit, dailyUsage int
+	shortReset, dailyReset time.Time
+}
+
+// Do waits until a request can be made without exceeding the rate limits, then makes it with the given client.
+// Requests that are rejected with a 429 are retried once the current window resets.
+func (l *RateLimiter) Do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	for {
+		if err := l.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		rsp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		l.update(rsp.Header)
+
+		if rsp.StatusCode != http.StatusTooManyRequests {
+			return rsp, nil
+		}
+		internal.DrainAndClose(rsp.Body)
+
+		// treat the current window as exhausted, even if the response didn't tell us so
+		l.mu.Lock()
+		if now := time.Now(); !l.shortReset.After(now) {
+			l.shortReset = now.Truncate(rateLimitWindow).Add(rateLimitWindow)
+		}
+		if l.shortLimit == 0 {
+			l.shortLimit = rateLimitReserve
+		}
+		l.shortUsage = l.shortLimit
+		l.mu.Unlock()
+	}
+}
+
+func (l *RateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if !now.Before(l.shortReset) {
+		l.shortUsage = 0
+	}
+	if now.After(l.dailyReset) {
+		l.dailyUsage = 0
+	}
+
+	if l.dailyLimit > 0 && l.dailyUsage >= l.dailyLimit-rateLimitReserve {
+		l.mu.Unlock()
+		return ErrDailyRateLimit
+	}
+
+	var delay time.Duration
+	if l.shortLimit > 0 && l.shortUsage >= l.shortLimit-rateLimitReserve {
+		delay = time.Until(l.shortReset)
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	if l.Logf != nil {
+		l.Logf("rate limit reached, pausing for %s", delay.Round(time.Second))
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// update records the limits and usage reported in response headers.  Strava reports both overall and read-only
+// limits; since all of our requests are reads, the read limits are used when present.
+func (l *RateLimiter) update(h http.Header) {
+	limits, usage := h.Get("X-ReadRateLimit-Limit"), h.Get("X-ReadRateLimit-Usage")
+	if limits == "" || usage == "" {
+		limits, usage = h.Get("X-RateLimit-Limit"), h.Get("X-RateLimit-Usage")
+	}
+
+	shortLimit, dailyLimit, ok1 := parseRatePair(limits)
+	shortUsage, dailyUsage, ok2 := parseRatePair(usage)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shortLimit, l.dailyLimit = shortLimit, dailyLimit
+	l.shortUsage, l.dailyUsage = shortUsage, dailyUsage
+	l.shortReset = now.Truncate(rateLimitWindow).Add(rateLimitWindow)
+	l.dailyReset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// parseRatePair parses a rate limit header value of the form "100,1000" (15-minute, daily).
+func parseRatePair(s string) (int, int, bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	a, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	b, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	return a, b, err1 == nil && err2 == nil
+}
+
+// Syncer copies an athlete's activities from the Strava API into the local activity store.
+type Syncer struct {
+	Db       *SqliteDb
+	Account  *ApiParams
+	Username string
+	Limiter  *RateLimiter
+
+	// Logf, if set, is called to report progress.
+	Logf func(format string, args ...interface{})
+}
+
+// SyncReport summarizes the differences found by Verify.
+type SyncReport struct {
+	Remote  int
+	Local   int
+	Missing []int64 // on Strava but not stored locally
+	Extra   []int64 // stored locally but no longer on Strava
+	Changed []int64 // stored locally, but different from what is on Strava
+}
+
+func (r *SyncReport) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Changed) == 0
+}
+
+// Backfill fetches the athlete's entire activity history, oldest first.  Progress is saved after every page, so an
+// interrupted backfill resumes where it left off, and running it again after it has completed fetches only
+// activities newer than the last one stored.
+func (s *Syncer) Backfill(ctx context.Context) error {
+	state, err := s.Db.query.FetchSyncState(ctx, s.Username)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	cursor := state.BackfillCursor
+	if cursor.IsZero() {
+		cursor = time.Unix(0, 0)
+	} else {
+		s.logf("resuming from %s", cursor.Format(time.RFC3339))
+	}
+
+	total := 0
+	for page := 1; ; page++ {
+		raws, err := s.fetchPage(ctx, cursor, time.Time{}, 1)
+		if err != nil {
+			return err
+		}
+
+		if len(raws) == 0 {
+			break
+		}
+
+		activities, err := s.Db.SaveActivities(ctx, s.Username, raws)
+		if err != nil {
+			return err
+		}
+
+		// with only an "after" bound, Strava returns activities oldest first
+		prev := cursor
+		for _, activity := range activities {
+			if t := activity.StartTime(); t.After(cursor) {
+				cursor = t
+			}
+		}
+
+		if err := s.saveState(ctx, cursor, false); err != nil {
+			return err
+		}
+
+		total += len(activities)
+		s.logf("page %d: stored %d activities through %s (%d total)", page, len(activities), cursor.Format("2006-01-02"), total)
+
+		if len(raws) < activitiesPageSize || !cursor.After(prev) {
+			break
+		}
+	}
+
+	if err := s.saveState(ctx, cursor, true); err != nil {
+		return err
+	}
+	s.Db.HistoryChanged(ctx, s.Username)
+
+	s.logf("backfill complete: stored %d activities", total)
+	return nil
+}
+
+// Resync re-fetches all activities that started in [start, finish), replacing the stored copies and removing any
+// that have since been deleted on Strava.
+func (s *Syncer) Resync(ctx context.Context, start, finish time.Time) error {
+	remote, err := s.fetchRange(ctx, start, finish)
+	if err != nil {
+		return err
+	}
+
+	raws := make([]json.RawMessage, 0, len(remote))
+	for _, raw := range remote {
+		raws = append(raws, raw)
+	}
+	if _, err := s.Db.SaveActivities(ctx, s.Username, raws); err != nil {
+		return err
+	}
+
+	local, err := s.Db.LoadActivities(ctx, s.Username, start, finish)
+	if err != nil {
+		return fmt.Errorf("failed to read stored activities: %w", err)
+	}
+
+	deleted := 0
+	for _, activity := range local {
+		if _, ok := remote[activity.Id]; !ok {
+			if err := s.Db.DeleteActivity(ctx, s.Username, activity.Id); err != nil {
+				return fmt.Errorf("failed to delete activity %d: %w", activity.Id, err)
+			}
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.Db.HistoryChanged(ctx, s.Username)
+	}
+
+	s.logf("resync complete: stored %d activities, deleted %d", len(remote), deleted)
+	return nil
+}
+
+// Verify compares the stored activities that started in [start, finish) against Strava, without changing anything.
+func (s *Syncer) Verify(ctx context.Context, start, finish time.Time) (*SyncReport, error) {
+	remote, err := s.fetchRange(ctx, start, finish)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := s.Db.LoadActivities(ctx, s.Username, start, finish)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored activities: %w", err)
+	}
+
+	report := &SyncReport{Remote: len(remote), Local: len(local)}
+	seen := make(map[int64]bool)
+	for _, activity := range local {
+		seen[activity.Id] = true
+
+		raw, ok := remote[activity.Id]
+		if !ok {
+			report.Extra = append(report.Extra, activity.Id)
+			continue
+		}
+
+		var other Activity
+		if err := json.Unmarshal(raw, &other); err != nil {
+			return nil, fmt.Errorf("failed to parse activity %d: %w", activity.Id, err)
+		}
+		if other != activity {
+			report.Changed = append(report.Changed, activity.Id)
+		}
+	}
+
+	for id := range remote {
+		if !seen[id] {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i] < report.Missing[j] })
+
+	return report, nil
+}
+
+// fetchRange fetches all activities that started in [start, finish), keyed by activity id.
+func (s *Syncer) fetchRange(ctx context.Context, start, finish time.Time) (map[int64]json.RawMessage, error) {
+	results := make(map[int64]json.RawMessage)
+	for page := 1; ; page++ {
+		// Strava's "after" bound is exclusive, but start is meant to be inclusive
+		raws, err := s.fetchPage(ctx, start.Add(-time.Second), finish, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, raw := range raws {
+			var activity Activity
+			if err := json.Unmarshal(raw, &activity); err != nil {
+				return nil, fmt.Errorf("failed to parse activity: %w", err)
+			}
+			results[activity.Id] = raw
+		}
+
+		s.logf("page %d: fetched %d activities (%d total)", page, len(raws), len(results))
+		if len(raws) < activitiesPageSize {
+			return results, nil
+		}
+	}
+}
+
+// fetchPage fetches one page of activities that started after "after" and (if non-zero) before "before".
+func (s *Syncer) fetchPage(ctx context.Context, after, before time.Time, page int) ([]json.RawMessage, error) {
+	// re-read on each page since a long sync can outlive an access token
+	accessToken, err := readAccessToken(ctx, s.Username, s.Db, s.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	qs := make(url.Values)
+	qs.Set("per_page", strconv.Itoa(activitiesPageSize))
+	qs.Set("page", strconv.Itoa(page))
+	qs.Set("after", strconv.FormatInt(after.Unix(), 10))
+	if !before.IsZero() {
+		qs.Set("before", strconv.FormatInt(before.Unix(), 10))
+	}
+
+	req, err := http.NewRequest("GET", "https://www.strava.com/api/v3/athlete/activities?"+qs.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	rsp, err := s.Limiter.Do(ctx, s.Account.client(), req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get: %w", err)
+	}
+	defer internal.DrainAndClose(rsp.Body)
+
+	if err := internal.CheckResponse(rsp); err != nil {
+		return nil, fmt.Errorf("failed to get: %w", err)
+	}
+
+	var raws []json.RawMessage
+	if err := json.NewDecoder(rsp.Body).Decode(&raws); err != nil {
+		return nil, fmt.Errorf("failed to parse body: %s", err)
+	}
+	return raws, nil
+}
+
+func (s *Syncer) saveState(ctx context.Context, cursor time.Time, complete bool) error {
+	err := s.Db.query.UpsertSyncState(ctx, storage.UpsertSyncStateParams{
+		Username:         s.Username,
+		BackfillCursor:   cursor.UTC(),
+		BackfillComplete: complete,
+		UpdatedTime:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	return nil
+}
+
+func (s *Syncer) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
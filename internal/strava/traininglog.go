@@ -0,0 +1,212 @@
+package strava
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+const (
+	logPeriodWeek  = "week"
+	logPeriodMonth = "month"
+
+	// number of weeks or months shown on each page of the training log
+	logBucketsPerPage = 12
+)
+
+// logBucket accumulates the runs in one week or month of the training log.
+type logBucket struct {
+	Start, End time.Time
+	Runs       int
+	Meters     float64
+	Seconds    float64
+	Longest    float64 // meters
+}
+
+// logRow is a single row of the training log table.
+type logRow struct {
+	Label       string
+	Miles       string
+	Time        string
+	Runs        int
+	LongestRun  string
+	AveragePace string
+	GoalMiles   string
+	Progress    string
+	OnTrack     bool
+	Current     bool
+}
+
+// logBucketStart returns the start of the week (Monday, as in ISO weeks) or month containing t.
+func logBucketStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == logPeriodMonth {
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// logBucketAdvance moves a bucket start forward (or backward, for negative n) by n weeks or months.
+func logBucketAdvance(start time.Time, period string, n int) time.Time {
+	if period == logPeriodMonth {
+		return start.AddDate(0, n, 0)
+	}
+	return start.AddDate(0, 0, 7*n)
+}
+
+func logBucketLabel(start time.Time, period string) string {
+	if period == logPeriodMonth {
+		return start.Format("January 2006")
+	}
+	year, week := start.ISOWeek()
+	return fmt.Sprintf("Week %d, %d (%s)", week, year, start.Format("Jan 2"))
+}
+
+// TrainingLogHandler serves the training log, which totals the athlete's runs by ISO week or by month and compares
+// each week or month with the mileage planned for it by the athlete's goal schedule.  The period and the last week or
+// month shown are set with the "period" and "date" query params.
+func TrainingLogHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams) {
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	period := logPeriodWeek
+	if r.URL.Query().Get("period") == logPeriodMonth {
+		period = logPeriodMonth
+	}
+
+	now := time.Now()
+	current := logBucketStart(now, period)
+	last := current
+	if s := r.URL.Query().Get("date"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			internal.HttpError(w, http.StatusBadRequest, "invalid date %q: %s", s, err)
+			return
+		}
+		last = logBucketStart(t, period)
+	}
+	if last.After(current) {
+		last = current
+	}
+
+	buckets := make([]*logBucket, logBucketsPerPage)
+	for i := range buckets {
+		start := logBucketAdvance(last, period, i+1-logBucketsPerPage)
+		buckets[i] = &logBucket{Start: start, End: logBucketAdvance(start, period, 1)}
+	}
+	queryStart, queryEnd := buckets[0].Start, buckets[len(buckets)-1].End
+
+	plan, err := loadMileagePlan(r.Context(), username, queryStart.Year(), queryEnd.Add(-time.Second).Year(), db)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read goals: %s", err)
+		return
+	}
+
+	activities, err := syncActivities(r.Context(), username, queryStart, queryEnd, db, account)
+	if err != nil {
+		if errors.Is(err, ErrNeedsAuth) {
+			http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+			return
+		}
+		internal.HttpError(w, http.StatusInternalServerError, "failed to query strava: %s", err)
+		return
+	}
+
+	decisions, err := db.ReadDuplicateDecisions(r.Context(), username)
+	if err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to read duplicate decisions: %s", err)
+		return
+	}
+	activities = decisions.Counted(activities)
+
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type != "Run" {
+			continue
+		}
+
+		start := activity.StartTime()
+		for _, bucket := range buckets {
+			if !start.Before(bucket.Start) && start.Before(bucket.End) {
+				bucket.Runs++
+				bucket.Meters += activity.DistanceMeters
+				bucket.Seconds += activity.MovingTime
+				if activity.DistanceMeters > bucket.Longest {
+					bucket.Longest = activity.DistanceMeters
+				}
+				break
+			}
+		}
+	}
+
+	pageUrl := func(period string, date time.Time) string {
+		qs := make(url.Values)
+		qs.Set("period", period)
+		qs.Set("date", date.Format("2006-01-02"))
+		return r.URL.Path + "?" + qs.Encode()
+	}
+
+	args := struct {
+		Period       string
+		Rows         []*logRow
+		OlderUrl     string
+		NewerUrl     string
+		PeriodToggle string
+	}{
+		Period:       period,
+		OlderUrl:     pageUrl(period, logBucketAdvance(last, period, -logBucketsPerPage)),
+		PeriodToggle: pageUrl(logPeriodMonth, last),
+	}
+	if last.Before(current) {
+		args.NewerUrl = pageUrl(period, logBucketAdvance(last, period, logBucketsPerPage))
+	}
+	if period == logPeriodMonth {
+		args.PeriodToggle = pageUrl(logPeriodWeek, last)
+	}
+
+	// newest first, like the activity list
+	for i := len(buckets) - 1; i >= 0; i-- {
+		bucket := buckets[i]
+
+		// the current week or month is compared with the goal pro-rated to today, not to its end
+		goalEnd := bucket.End
+		if bucket.Start.Equal(current) {
+			goalEnd = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		}
+		goalMiles := plan.Miles(bucket.Start, goalEnd)
+		miles := bucket.Meters / metersPerMile
+
+		row := &logRow{
+			Label:       logBucketLabel(bucket.Start, period),
+			Miles:       fmt.Sprintf("%.1f", miles),
+			Time:        formatHours(bucket.Seconds),
+			Runs:        bucket.Runs,
+			LongestRun:  "-",
+			AveragePace: "-",
+			GoalMiles:   "-",
+			OnTrack:     miles >= goalMiles,
+			Current:     bucket.Start.Equal(current),
+		}
+		if goalMiles > 0 {
+			row.GoalMiles = fmt.Sprintf("%.1f", goalMiles)
+			row.Progress = fmt.Sprintf("%.0f%%", 100*miles/goalMiles)
+		}
+		if bucket.Runs > 0 {
+			row.LongestRun = fmt.Sprintf("%.1f", bucket.Longest/metersPerMile)
+			row.AveragePace = formatPace(bucket.Meters/bucket.Seconds, metersPerMile)
+		}
+		args.Rows = append(args.Rows, row)
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
@@ -0,0 +1,309 @@
+package strava
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ianrose14/website/internal"
+)
+
+const (
+	// how far back to look for efforts to estimate VDOT from
+	vdotWindowDays = 180
+
+	// number of best efforts listed on the calculator page
+	vdotMaxEfforts = 5
+)
+
+// vdotDistances are the standard distances that equivalent times are predicted for, in display order.  Longer
+// distances are left out since the VDOT model doesn't account for fueling and fatigue over ultras.
+var vdotDistances = []string{"5k", "10k", "15k", "10mi", "half", "marathon"}
+
+// vdotPaces are the training intensities recommended by the calculator, as fractions of VO2max (from Jack Daniels'
+// Running Formula).  Easy running covers a range of intensities; the others are a single target.
+var vdotPaces = []struct {
+	Name     string
+	Min, Max float64
+}{
+	{"easy", 0.59, 0.74},
+	{"tempo", 0.88, 0.88},
+	{"interval", 0.975, 0.975},
+}
+
+// vdotOxygenCost returns the oxygen cost (ml/kg/min) of running at the given speed in meters per minute, per Daniels
+// and Gilbert.
+func vdotOxygenCost(v float64) float64 {
+	return -4.60 + 0.182258*v + 0.000104*v*v
+}
+
+// vdotFractionMax returns the fraction of VO2max that can be sustained for a race lasting the given number of
+// minutes, per Daniels and Gilbert.
+func vdotFractionMax(minutes float64) float64 {
+	return 0.8 + 0.1894393*math.Exp(-0.012778*minutes) + 0.2989558*math.Exp(-0.1932605*minutes)
+}
+
+// vdot returns Daniels' VDOT for running the given distance (in meters) in the given time (in seconds).
+func vdot(meters, seconds float64) float64 {
+	minutes := seconds / 60
+	return vdotOxygenCost(meters/minutes) / vdotFractionMax(minutes)
+}
+
+// vdotPredict returns the time, in seconds, that an athlete with the given VDOT is predicted to cover the given
+// distance in.
+func vdotPredict(v, meters float64) float64 {
+	// vdot() decreases as the time increases, so binary search between "impossibly fast" and "walking pace"
+	lo, hi := meters/10, meters
+	for i := 0; i < 100 && hi-lo > 0.1; i++ {
+		mid := (lo + hi) / 2
+		if vdot(meters, mid) > v {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// vdotSpeed returns the speed, in meters per second, at which an athlete with the given VDOT runs at the given
+// fraction of their VO2max.
+func vdotSpeed(v, fraction float64) float64 {
+	// solve vdotOxygenCost(speed) = fraction * v for speed, in meters per minute
+	a, b, c := 0.000104, 0.182258, -4.60-fraction*v
+	return (-b + math.Sqrt(b*b-4*a*c)) / (2 * a) / 60
+}
+
+// vdotEffort is an activity that the athlete's VDOT was estimated from.
+type vdotEffort struct {
+	Activity *Activity
+	Vdot     float64
+}
+
+// bestVdotEfforts returns the runs that VDOT can be estimated from, best first.  If there are any races, only those
+// are used; otherwise the fastest training runs stand in for them (and will likely underestimate the athlete).
+func bestVdotEfforts(activities []Activity) (efforts []*vdotEffort, fromRaces bool) {
+	var races, runs []*vdotEffort
+	for i := range activities {
+		activity := &activities[i]
+		if activity.Type != "Run" || activity.DistanceMeters < minPredictionMeters || activity.MovingTime <= 0 {
+			continue
+		}
+
+		effort := &vdotEffort{Activity: activity, Vdot: vdot(activity.DistanceMeters, activity.MovingTime)}
+		if activity.IsRace() {
+			races = append(races, effort)
+		}
+		runs = append(runs, effort)
+	}
+
+	efforts = runs
+	if len(races) > 0 {
+		efforts, fromRaces = races, true
+	}
+
+	sort.Slice(efforts, func(i, j int) bool {
+		return efforts[i].Vdot > efforts[j].Vdot
+	})
+	if len(efforts) > vdotMaxEfforts {
+		efforts = efforts[:vdotMaxEfforts]
+	}
+	return efforts, fromRaces
+}
+
+// vdotResult is the calculator output, as rendered on the page and returned as JSON.
+type vdotResult struct {
+	Units       string            `json:"units"`
+	Vdot        float64           `json:"vdot,omitempty"`
+	FromRaces   bool              `json:"from_races"`
+	Efforts     []*vdotEffortView `json:"efforts"`
+	Predictions []*vdotPrediction `json:"predictions"`
+	Paces       []*vdotPaceView   `json:"paces"`
+
+	// set if the athlete gave their age and sex, and this server has age-grading tables
+	Age      int     `json:"age,omitempty"`
+	Sex      string  `json:"sex,omitempty"`
+	AgeGrade float64 `json:"age_grade,omitempty"`
+}
+
+type vdotEffortView struct {
+	Id             int64   `json:"id"`
+	Name           string  `json:"name"`
+	Date           string  `json:"date"`
+	DistanceMeters float64 `json:"distance_meters"`
+	MovingTime     float64 `json:"moving_time"`
+	Vdot           float64 `json:"vdot"`
+	AgeGrade       float64 `json:"age_grade,omitempty"`
+	Url            string  `json:"-"`
+	Distance       string  `json:"-"`
+	Time           string  `json:"-"`
+}
+
+type vdotPrediction struct {
+	Distance string  `json:"distance"`
+	Meters   float64 `json:"meters"`
+	Seconds  float64 `json:"seconds"`
+	Time     string  `json:"time"`
+	Pace     string  `json:"pace"`
+	AgeGrade float64 `json:"age_grade,omitempty"`
+}
+
+type vdotPaceView struct {
+	Name    string `json:"name"`
+	Fastest string `json:"fastest"`
+	Slowest string `json:"slowest"`
+}
+
+// ageGrader returns the age grade for covering meters in seconds, or false if it can't be graded.
+type ageGrader func(meters, seconds float64) (float64, bool)
+
+// newVdotResult builds the calculator's results.  If grade is non-nil, efforts and predictions are also age-graded.
+func newVdotResult(activities []Activity, unitMeters float64, units string, grade ageGrader) *vdotResult {
+	efforts, fromRaces := bestVdotEfforts(activities)
+	if grade == nil {
+		grade = func(float64, float64) (float64, bool) { return 0, false }
+	}
+
+	result := &vdotResult{
+		Units:       units,
+		FromRaces:   fromRaces,
+		Efforts:     []*vdotEffortView{},
+		Predictions: []*vdotPrediction{},
+		Paces:       []*vdotPaceView{},
+	}
+	if len(efforts) == 0 {
+		return result
+	}
+
+	for _, effort := range efforts {
+		activity := effort.Activity
+		ageGrade, _ := grade(activity.DistanceMeters, activity.MovingTime)
+		result.Efforts = append(result.Efforts, &vdotEffortView{
+			Id:             activity.Id,
+			Name:           activity.Name,
+			Date:           activity.StartTime().Format("Jan 2, 2006"),
+			DistanceMeters: activity.DistanceMeters,
+			MovingTime:     activity.MovingTime,
+			Vdot:           math.Round(effort.Vdot*10) / 10,
+			AgeGrade:       math.Round(ageGrade*10) / 10,
+			Url:            fmt.Sprintf("/running/activity/%d", activity.Id),
+			Distance:       fmt.Sprintf("%.2f %s", activity.DistanceMeters/unitMeters, units),
+			Time:           formatDuration(activity.MovingTime),
+		})
+	}
+
+	v := efforts[0].Vdot
+	result.Vdot = math.Round(v*10) / 10
+	result.AgeGrade = result.Efforts[0].AgeGrade
+
+	for _, name := range vdotDistances {
+		meters := standardDistances[name]
+		seconds := vdotPredict(v, meters)
+		ageGrade, _ := grade(meters, seconds)
+		result.Predictions = append(result.Predictions, &vdotPrediction{
+			Distance: formatRaceDistance(meters),
+			Meters:   meters,
+			Seconds:  math.Round(seconds),
+			Time:     formatDuration(seconds),
+			Pace:     formatPace(meters/seconds, unitMeters),
+			AgeGrade: math.Round(ageGrade*10) / 10,
+		})
+	}
+
+	for _, p := range vdotPaces {
+		result.Paces = append(result.Paces, &vdotPaceView{
+			Name:    p.Name,
+			Fastest: formatPace(vdotSpeed(v, p.Max), unitMeters),
+			Slowest: formatPace(vdotSpeed(v, p.Min), unitMeters),
+		})
+	}
+
+	return result
+}
+
+// VdotHandler serves the race-equivalency calculator, which estimates the athlete's VDOT from their best recent
+// efforts and shows equivalent race times and training paces.  Given the athlete's age and sex (in the age and sex
+// query params), efforts and predictions are also age-graded with the given tables, if any.  Requests for
+// /running/vdot.json get the same results as JSON.
+func VdotHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Template, db *SqliteDb, account *ApiParams, factors *AgeFactors) {
+	username, ok := requireAthlete(w, r, db, account)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	activities, err := syncActivities(r.Context(), username, now.AddDate(0, 0, -vdotWindowDays), now, db, account)
+	if err != nil {
+		if errors.Is(err, ErrNeedsAuth) {
+			http.Redirect(w, r, getAuthUrl(r, account), http.StatusTemporaryRedirect)
+			return
+		}
+		internal.HttpError(w, http.StatusInternalServerError, "failed to query strava: %s", err)
+		return
+	}
+
+	units := "mi"
+	unitMeters := metersPerMile
+	if r.URL.Query().Get("units") == "km" {
+		units = "km"
+		unitMeters = metersPerKm
+	}
+
+	age, _ := strconv.Atoi(r.URL.Query().Get("age"))
+	sex := strings.ToUpper(r.URL.Query().Get("sex"))
+	var grade ageGrader
+	if factors != nil && age > 0 && (sex == "M" || sex == "F") {
+		grade = func(meters, seconds float64) (float64, bool) {
+			return factors.Grade(sex, age, meters, seconds)
+		}
+	}
+
+	result := newVdotResult(activities, unitMeters, units, grade)
+	if grade != nil {
+		result.Age, result.Sex = age, sex
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			internal.HttpError(w, http.StatusInternalServerError, "failed to json-encode results: %s", err)
+		}
+		return
+	}
+
+	qs := r.URL.Query()
+	if units == "km" {
+		qs.Del("units")
+	} else {
+		qs.Set("units", "km")
+	}
+
+	args := struct {
+		*vdotResult
+		WindowDays     int
+		UnitsToggleUrl string
+		AgeGrading     bool
+		FormUnits      string
+		FormAge        string
+		FormSex        string
+	}{
+		vdotResult:     result,
+		WindowDays:     vdotWindowDays,
+		UnitsToggleUrl: r.URL.Path + "?" + qs.Encode(),
+		AgeGrading:     factors != nil,
+		FormUnits:      r.URL.Query().Get("units"),
+		FormAge:        r.URL.Query().Get("age"),
+		FormSex:        sex,
+	}
+
+	if err := tmpl.Execute(w, &args); err != nil {
+		internal.HttpError(w, http.StatusInternalServerError, "failed to render template: %s", err)
+		return
+	}
+}
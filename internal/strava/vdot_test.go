@@ -0,0 +1,67 @@
+package strava
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestVdot(t *testing.T) {
+	// compare with the tables in Daniels' Running Formula
+	tests := []struct {
+		name    string
+		meters  float64
+		seconds float64
+		want    float64
+	}{
+		{"5k in 20:00", 5000, 1200, 49.8},
+		{"marathon in 3:00:00", 42195, 10800, 53.5},
+		{"10k in 50:00", 10000, 3000, 40.0},
+	}
+
+	for _, tt := range tests {
+		if got := vdot(tt.meters, tt.seconds); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%s: vdot = %.2f, want %.1f", tt.name, got, tt.want)
+		}
+		// predicting the same distance should give back the same time
+		if got := vdotPredict(vdot(tt.meters, tt.seconds), tt.meters); math.Abs(got-tt.seconds) > 1 {
+			t.Errorf("%s: predicted %.0fs, want %.0fs", tt.name, got, tt.seconds)
+		}
+	}
+}
+
+func TestNewVdotResultAgeGrades(t *testing.T) {
+	factors, err := parseAgeFactors(strings.NewReader(testAgeFactors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activities := []Activity{
+		{Id: 1, Name: "parkrun", Type: "Run", DistanceMeters: 5000, MovingTime: 1200, WorkoutType: WorkoutTypeRace,
+			StartDate: "2024-05-04T09:00:00Z"},
+	}
+
+	result := newVdotResult(activities, metersPerMile, "mi", nil)
+	if result.AgeGrade != 0 || result.Efforts[0].AgeGrade != 0 {
+		t.Errorf("age graded without tables: %+v", result)
+	}
+
+	result = newVdotResult(activities, metersPerMile, "mi", func(meters, seconds float64) (float64, bool) {
+		return factors.Grade("M", 50, meters, seconds)
+	})
+	if result.AgeGrade != 75.6 || result.Efforts[0].AgeGrade != 75.6 {
+		t.Errorf("age grade %.1f (effort %.1f), want 75.6", result.AgeGrade, result.Efforts[0].AgeGrade)
+	}
+	for _, p := range result.Predictions {
+		// equivalent performances should have about the same age grade, where the tables cover them
+		switch p.Distance {
+		case "5K", "10K":
+			if math.Abs(p.AgeGrade-75.6) > 4 {
+				t.Errorf("%s prediction is age graded %.1f, want about 75.6", p.Distance, p.AgeGrade)
+			}
+		default:
+			if p.AgeGrade != 0 {
+				t.Errorf("%s prediction is age graded %.1f, but the tables don't cover it", p.Distance, p.AgeGrade)
+			}
+		}
+	}
+}